@@ -0,0 +1,111 @@
+// Package auth holds the flags shared by commands which authorize requests to
+// the blob service. Commands define the account-name and account-key flags
+// themselves.
+package auth
+
+import (
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/urfave/cli"
+)
+
+// Flags configure how requests are authorized other than with an account key
+// given on the command line.
+var Flags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "account-key-file",
+		Usage: "A file holding the Azure Blob account key",
+	},
+	cli.StringFlag{
+		Name:  "sas-token-file",
+		Usage: "A file holding a SAS token to authorize with instead of the account key",
+	},
+	cli.StringFlag{
+		Name:  "connection-string-file",
+		Usage: "A file holding an Azure Storage connection string",
+	},
+	cli.StringFlag{
+		Name:  "blob-endpoint",
+		Usage: "The URL of the account's blob service (defaults to the one derived from the account name)",
+	},
+	cli.StringFlag{
+		Name:  "tenant-id",
+		Usage: "Azure AD tenant to request tokens from",
+	},
+	cli.StringFlag{
+		Name:  "client-id",
+		Usage: "Azure AD application to authorize as instead of using the account key",
+	},
+	cli.StringFlag{
+		Name:  "client-secret",
+		Usage: "Azure AD application secret",
+	},
+	cli.StringFlag{
+		Name:  "authority-url",
+		Usage: "The endpoint tokens are requested from (defaults to Azure AD)",
+	},
+	cli.BoolFlag{
+		Name:  "managed-identity",
+		Usage: "Authorize as the VM's managed identity instead of using the account key",
+	},
+	cli.StringFlag{
+		Name:  "identity-client-id",
+		Usage: "The user assigned managed identity to authorize as (defaults to the system assigned one)",
+	},
+	cli.StringFlag{
+		Name:  "imds-endpoint",
+		Usage: "The endpoint managed identity tokens are requested from (defaults to the instance metadata service)",
+	},
+}
+
+// Keys maps the flags in Flags which don't match the name of a setting to the
+// setting they set.
+var Keys = map[string]string{
+	"tenant-id":          "oauth.tenantId",
+	"client-id":          "oauth.clientId",
+	"client-secret":      "oauth.clientSecret",
+	"authority-url":      "oauth.authorityUrl",
+	"managed-identity":   "managedIdentity",
+	"identity-client-id": "managedIdentity.clientId",
+	"imds-endpoint":      "managedIdentity.endpoint",
+}
+
+// Credentials returns the credentials given by the account-name and
+// account-key flags and Flags. They still need resolving.
+func Credentials(context *cli.Context) config.Credentials {
+	return config.Credentials{
+		AzureAccountName:     context.String("account-name"),
+		AzureAccountKey:      context.String("account-key"),
+		AccountKeyFile:       context.String("account-key-file"),
+		SASTokenFile:         context.String("sas-token-file"),
+		ConnectionStringFile: context.String("connection-string-file"),
+		BlobEndpoint:         context.String("blob-endpoint"),
+		OAuth:                OAuth(context),
+		ManagedIdentity:      ManagedIdentity(context),
+	}
+}
+
+// OAuth returns the OAuth configuration given by Flags, or nil if there isn't
+// one.
+func OAuth(context *cli.Context) *config.OAuth {
+	if context.String("client-id") == "" {
+		return nil
+	}
+	return &config.OAuth{
+		TenantID:     context.String("tenant-id"),
+		ClientID:     context.String("client-id"),
+		ClientSecret: context.String("client-secret"),
+		AuthorityURL: context.String("authority-url"),
+	}
+}
+
+// ManagedIdentity returns the managed identity configuration given by Flags,
+// or nil if there isn't one.
+func ManagedIdentity(context *cli.Context) *config.ManagedIdentity {
+	if !context.Bool("managed-identity") {
+		return nil
+	}
+	return &config.ManagedIdentity{
+		ClientID: context.String("identity-client-id"),
+		Endpoint: context.String("imds-endpoint"),
+	}
+}
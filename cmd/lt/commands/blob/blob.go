@@ -1,13 +1,13 @@
 package blob
 
 import (
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/cmd/lt/commands/auth"
+	"github.com/ehotinger/lightningfs/credential"
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
-const (
-	blobFmt = "https://%s.blob.core.windows.net/%s"
-)
-
 // Command performs blob operations.
 var Command = cli.Command{
 	Name:      "blob",
@@ -17,5 +17,51 @@ var Command = cli.Command{
 	Subcommands: []cli.Command{
 		propsCommand,
 		uploadCommand,
+		snapshotCommand,
+	},
+}
+
+// flags identify the blob each subcommand operates on.
+var flags = append([]cli.Flag{
+	cli.StringFlag{
+		Name:  "account-name",
+		Usage: "Azure Blob account name",
+	},
+	cli.StringFlag{
+		Name:  "account-key",
+		Usage: "Azure Blob account key",
 	},
+	cli.StringFlag{
+		Name:  "container-name",
+		Usage: "Azure Blob container name",
+	},
+	cli.StringFlag{
+		Name:  "blob-name",
+		Usage: "The Azure Blob name",
+	},
+}, auth.Flags...)
+
+// newBlobURL returns the URL of the blob the flags identify.
+func newBlobURL(context *cli.Context) (azblob.BlobURL, error) {
+	var (
+		creds         = auth.Credentials(context)
+		containerName = context.String("container-name")
+		blobName      = context.String("blob-name")
+	)
+
+	if containerName == "" {
+		return azblob.BlobURL{}, errors.New("container name is required")
+	}
+	if blobName == "" {
+		return azblob.BlobURL{}, errors.New("blob name is required")
+	}
+	if err := creds.Resolve(); err != nil {
+		return azblob.BlobURL{}, err
+	}
+
+	serviceURL, err := credential.NewServiceURL(creds, azblob.PipelineOptions{})
+	if err != nil {
+		return azblob.BlobURL{}, err
+	}
+	return serviceURL.NewContainerURL(containerName).NewBlobURL(blobName), nil
 }
@@ -0,0 +1,29 @@
+package blob
+
+import (
+	gocontext "context"
+	"fmt"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/urfave/cli"
+)
+
+var snapshotCommand = cli.Command{
+	Name:      "snapshot",
+	Usage:     "snapshot a blob",
+	ArgsUsage: "",
+	Flags:     flags,
+	Action: func(context *cli.Context) error {
+		blobURL, err := newBlobURL(context)
+		if err != nil {
+			return err
+		}
+
+		resp, err := blobURL.CreateSnapshot(gocontext.Background(), nil, azblob.BlobAccessConditions{})
+		if err != nil {
+			return err
+		}
+		fmt.Println(resp.Snapshot())
+		return nil
+	},
+}
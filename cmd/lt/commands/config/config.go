@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/ehotinger/lightningfs/cmd/lt/commands/mount"
+	lightningconfig "github.com/ehotinger/lightningfs/config"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Command inspects mount configuration.
+var Command = cli.Command{
+	Name:      "config",
+	Usage:     "validate and show mount configuration",
+	ArgsUsage: "",
+	Flags:     []cli.Flag{},
+	Subcommands: []cli.Command{
+		validateCommand,
+		showCommand,
+	},
+}
+
+var validateCommand = cli.Command{
+	Name:      "validate",
+	Usage:     "check the configuration a mount with the same flags would use",
+	ArgsUsage: "",
+	Flags:     mount.Flags,
+	Action: func(context *cli.Context) error {
+		if _, err := mount.Load(context); err != nil {
+			return err
+		}
+		fmt.Println("configuration is valid")
+		return nil
+	},
+}
+
+var showCommand = cli.Command{
+	Name:      "show",
+	Usage:     "print the configuration file, with secrets redacted",
+	ArgsUsage: "",
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "effective",
+			Usage: "Print the configuration a mount with the same flags would use, after applying defaults, the environment and flags",
+		},
+	}, mount.Flags...),
+	Action: func(context *cli.Context) error {
+		var (
+			cfg *lightningconfig.Config
+			err error
+		)
+		if context.Bool("effective") {
+			cfg, err = mount.Load(context)
+		} else {
+			configFile := context.String("config-file")
+			if configFile == "" {
+				return errors.New("config file is required without --effective")
+			}
+			cfg, err = lightningconfig.NewConfigFromFile(configFile)
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err := yaml.Marshal(cfg.Redacted())
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	},
+}
@@ -0,0 +1,88 @@
+package gc
+
+import (
+	gocontext "context"
+	"fmt"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/cmd/lt/commands/auth"
+	"github.com/ehotinger/lightningfs/credential"
+	"github.com/ehotinger/lightningfs/dedup"
+	"github.com/ehotinger/lightningfs/defaults"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// Command deletes unreferenced chunks of a deduplicated mount.
+var Command = cli.Command{
+	Name:      "gc",
+	Usage:     "delete chunks no longer referenced by any file of a deduplicated mount",
+	ArgsUsage: "",
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "account-name",
+			Usage: "Azure Blob account name",
+		},
+		cli.StringFlag{
+			Name:  "account-key",
+			Usage: "Azure Blob account key",
+		},
+		cli.StringFlag{
+			Name:  "container-name",
+			Usage: "Azure Blob container name",
+		},
+		cli.StringFlag{
+			Name:  "prefix",
+			Usage: "The blob prefix mounted",
+		},
+		cli.DurationFlag{
+			Name:  "grace-period",
+			Usage: "How long a chunk has to go unreferenced before it's deleted",
+			Value: defaults.GCGracePeriod,
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Report what would be deleted without deleting anything",
+		},
+		cli.BoolFlag{
+			Name:  "ignore-deleted",
+			Usage: "Delete chunks even if soft deleted files may reference them",
+		},
+	}, auth.Flags...),
+	Action: func(context *cli.Context) error {
+		var (
+			creds         = auth.Credentials(context)
+			containerName = context.String("container-name")
+		)
+
+		if err := creds.Resolve(); err != nil {
+			return err
+		}
+		if containerName == "" {
+			return errors.New("container name is required")
+		}
+
+		serviceURL, err := credential.NewServiceURL(creds, azblob.PipelineOptions{})
+		if err != nil {
+			return err
+		}
+
+		result, err := dedup.CollectGarbage(gocontext.Background(), serviceURL.NewContainerURL(containerName), dedup.GCOptions{
+			Prefix:        context.String("prefix"),
+			GracePeriod:   context.Duration("grace-period"),
+			DryRun:        context.Bool("dry-run"),
+			IgnoreDeleted: context.Bool("ignore-deleted"),
+		})
+		if err != nil {
+			return err
+		}
+
+		verb := "Deleted"
+		if context.Bool("dry-run") {
+			verb = "Would delete"
+		}
+		fmt.Printf("Found %d manifests and %d chunks\n", result.Manifests, result.Chunks)
+		fmt.Printf("%s %d chunks, freeing %d bytes\n", verb, result.Deleted, result.Freed)
+		return nil
+	},
+}
@@ -0,0 +1,52 @@
+package mount
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/ehotinger/lightningfs/control"
+)
+
+// statsTimeout is how long a mount waits to count its files for lt status,
+// well within the time lt status waits for it.
+const statsTimeout = time.Second
+
+// controlled exposes a mount to lt status and lt unmount.
+type controlled struct {
+	*stopper
+	started time.Time
+}
+
+func (c *controlled) Status() control.Status {
+	mntPoint, err := filepath.Abs(c.mntPoint)
+	if err != nil {
+		mntPoint = c.mntPoint
+	}
+	status := control.Status{
+		MountPoint: mntPoint,
+		PID:        os.Getpid(),
+		Started:    c.started,
+	}
+
+	// Flushing holds the file system's lock, so its stats can't be had.
+	if atomic.LoadInt32(&c.stopping) != 0 {
+		status.Unmounting = true
+		return status
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+	stats := c.server.Stats(ctx)
+	status.Containers = stats.Containers
+	status.Busy = stats.Busy
+	status.DirtyBytes = stats.DirtyBytes
+	status.UploadQueue = stats.DirtyFiles
+	status.CacheBytes = stats.MemoryBytes + stats.StagedBytes
+	return status
+}
+
+func (c *controlled) Unmount() error {
+	return c.stop()
+}
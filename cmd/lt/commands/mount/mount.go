@@ -4,11 +4,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	gocontext "context"
 
+	"github.com/ehotinger/lightningfs/cmd/lt/commands/auth"
 	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/control"
 	"github.com/ehotinger/lightningfs/defaults"
 	"github.com/ehotinger/lightningfs/fs"
 	"github.com/jacobsa/fuse"
@@ -16,37 +23,98 @@ import (
 	"github.com/urfave/cli"
 )
 
+// Flags configure a mount. Those which are set override the config file and
+// the environment.
+var Flags = append([]cli.Flag{
+	cli.BoolFlag{
+		Name:  "debug",
+		Usage: "Enable debug mode",
+	},
+	cli.StringFlag{
+		Name:  "account-name",
+		Usage: "Azure Blob account name",
+	},
+	cli.StringFlag{
+		Name:  "account-key",
+		Usage: "Azure Blob account key",
+	},
+	cli.StringFlag{
+		Name:  "container-name",
+		Usage: "Azure Blob container name",
+	},
+	cli.StringFlag{
+		Name:  "cache-path",
+		Usage: "The location of the disk cache",
+	},
+	cli.StringFlag{
+		Name:  "staging-path",
+		Usage: "The location dirty file data is spilled to (defaults to the cache path)",
+	},
+	cli.Int64Flag{
+		Name:  "memory-budget",
+		Usage: "The number of bytes of file data to hold in memory before spilling to disk",
+	},
+	cli.BoolFlag{
+		Name:  "lease-writes",
+		Usage: "Lease blobs while they're being written to exclude writers from other mounts",
+	},
+	cli.StringFlag{
+		Name:  "encryption-key-file",
+		Usage: "A file holding a base64 encoded 256 bit key to encrypt files with",
+	},
+	cli.BoolFlag{
+		Name:  "dedup",
+		Usage: "Store files as deduplicated chunks under .chunks",
+	},
+	cli.StringFlag{
+		Name:  "default-tier",
+		Usage: "The access tier new files are stored in (Hot, Cool or Archive)",
+	},
+	cli.DurationFlag{
+		Name:  "attribute-ttl",
+		Usage: "How long the kernel may cache file attributes",
+	},
+	cli.DurationFlag{
+		Name:  "entry-ttl",
+		Usage: "How long the kernel may cache directory entries",
+	},
+	cli.DurationFlag{
+		Name:  "negative-ttl",
+		Usage: "How long the kernel may cache failed lookups (disabled if zero)",
+	},
+	cli.DurationFlag{
+		Name:  "poll-interval",
+		Usage: "How often to poll the container for changes (disabled if zero)",
+	},
+	cli.DurationFlag{
+		Name:  "shutdown-timeout",
+		Usage: "How long unflushed files are given to upload when the mount is stopped",
+	},
+	cli.StringFlag{
+		Name:  "prefix",
+		Usage: "The blob prefix to mount as the root",
+	},
+	cli.BoolFlag{
+		Name:  "read-only",
+		Usage: "Mount read-only and cache the data aggressively",
+	},
+	cli.StringFlag{
+		Name:  "config-file",
+		Usage: "The location of the configuration file",
+	},
+	cli.StringFlag{
+		Name:  "control-dir",
+		Usage: "The directory of the sockets lt status and lt unmount use",
+		Value: control.DefaultDir(),
+	},
+}, auth.Flags...)
+
 // Command performs a mount.
 var Command = cli.Command{
 	Name:      "mount",
 	Usage:     "perform a mount",
 	ArgsUsage: "[mount]",
-	Flags: []cli.Flag{
-		cli.BoolFlag{
-			Name:  "debug",
-			Usage: "Enable debug mode",
-		},
-		cli.StringFlag{
-			Name:  "account-name",
-			Usage: "Azure Blob account name",
-		},
-		cli.StringFlag{
-			Name:  "account-key",
-			Usage: "Azure Blob account key",
-		},
-		cli.StringFlag{
-			Name:  "container-name",
-			Usage: "Azure Blob container name",
-		},
-		cli.StringFlag{
-			Name:  "cache-path",
-			Usage: "The location of the disk cache",
-		},
-		cli.StringFlag{
-			Name:  "config-file",
-			Usage: "The location of the configuration file",
-		},
-	},
+	Flags:     Flags,
 	Action: func(context *cli.Context) error {
 		var (
 			mntPoint   = context.Args().First()
@@ -54,20 +122,12 @@ var Command = cli.Command{
 			configFile = context.String("config-file")
 		)
 
-		var cfg *config.Config
-		if configFile == "" {
-			accountName := context.String("account-name")
-			accountKey := context.String("account-key")
-			containerName := context.String("container-name")
-			cachePath := context.String("cache-path")
-			cfg = config.NewConfig(accountName, accountKey, containerName, cachePath)
-		} else {
+		if configFile != "" {
 			log.Println("Loading configuration...")
-			var err error
-			cfg, err = config.NewConfigFromFile(configFile)
-			if err != nil {
-				return err
-			}
+		}
+		cfg, err := Load(context)
+		if err != nil {
+			return err
 		}
 
 		if mntPoint == "" {
@@ -76,38 +136,136 @@ var Command = cli.Command{
 
 		fmt.Fprintf(os.Stdout, "Using %s as the mount point\n", mntPoint)
 
-		if cfg.AzureAccountName == "" {
-			return errors.New("account name is required")
-		}
-		if cfg.AzureAccountKey == "" {
-			return errors.New("account key is required")
-		}
-
 		// TODO:
 		// Allow parallelism in the file system implementation
 		// to help flush out potential bugs.
 		runtime.GOMAXPROCS(2)
 		server, err := fs.NewLightningFS(cfg, 0, 0)
 		if err != nil {
-			log.Fatalf("failed to setup server: %v", err)
+			return errors.Wrap(err, "failed to setup server")
 		}
 		fuseCfg := &fuse.MountConfig{
-			ReadOnly: false,
+			ReadOnly: cfg.ReadOnly,
 			FSName:   "lightningfs",
 		}
 		if debug {
 			fuseCfg.DebugLogger = log.New(os.Stdout, "DEBUG: ", 0)
 		}
 
+		l, err := control.Listen(context.String("control-dir"), mntPoint)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+
 		mountedFS, err := fuse.Mount(mntPoint, server, fuseCfg)
 		if err != nil {
-			log.Fatalf("failed to mount: %v", err)
+			return errors.Wrap(err, "failed to mount")
 		}
 
+		s := &stopper{mntPoint: mntPoint, server: server}
+		ctl := control.NewServer(&controlled{stopper: s, started: time.Now()})
+		go ctl.Serve(l)
+		go watchConfig(context, server)
+		go handleSignals(s)
+
 		if err = mountedFS.Join(gocontext.Background()); err != nil {
-			log.Fatalf("failed to unmount: %v", err)
+			return errors.Wrap(err, "failed to unmount")
 		}
 
-		return nil
+		// Let an lt unmount which unmounted us hear how it went.
+		ctl.Shutdown(gocontext.Background())
+		return s.result()
 	},
 }
+
+// watchConfig reloads the config whenever the process receives SIGHUP or the
+// config file changes.
+func watchConfig(context *cli.Context, server *fs.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// Without a config file, there's nothing to check.
+	var check <-chan time.Time
+	configFile := context.String("config-file")
+	if configFile != "" {
+		ticker := time.NewTicker(defaults.ConfigCheckInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+	modTime := fileModTime(configFile)
+
+	for {
+		select {
+		case <-hup:
+			log.Println("Received SIGHUP, reloading configuration...")
+		case <-check:
+			t := fileModTime(configFile)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+			log.Println("Configuration file changed, reloading...")
+		}
+
+		cfg, err := Load(context)
+		if err != nil {
+			log.Printf("failed to reload configuration: %v", err)
+			continue
+		}
+		if err := server.Reload(cfg); err != nil {
+			log.Printf("failed to reload configuration: %v", err)
+		}
+	}
+}
+
+// fileModTime returns when a file was last modified, or the zero time if it
+// can't be read.
+func fileModTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Load loads the config from the config file, the environment and the flags
+// which are set, in increasing order of precedence.
+func Load(context *cli.Context) (*config.Config, error) {
+	keys := make(map[string]string)
+	for _, key := range config.Keys() {
+		keys[normalize(key)] = key
+	}
+	for flag, key := range auth.Keys {
+		keys[normalize(flag)] = key
+	}
+
+	overrides := make(map[string]string)
+	for _, flag := range Flags {
+		name := flag.GetName()
+		key, ok := keys[normalize(name)]
+		if !ok || !context.IsSet(name) {
+			continue
+		}
+		switch flag.(type) {
+		case cli.BoolFlag:
+			overrides[key] = strconv.FormatBool(context.Bool(name))
+		case cli.DurationFlag:
+			overrides[key] = context.Duration(name).String()
+		case cli.Int64Flag:
+			overrides[key] = strconv.FormatInt(context.Int64(name), 10)
+		default:
+			overrides[key] = context.String(name)
+		}
+	}
+	return config.Load(context.String("config-file"), overrides)
+}
+
+// normalize maps flag names and setting keys to a common form, e.g.
+// account-name and accountName to accountname.
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", ".", "").Replace(name))
+}
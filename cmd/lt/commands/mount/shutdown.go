@@ -0,0 +1,75 @@
+package mount
+
+import (
+	gocontext "context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/ehotinger/lightningfs/fs"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+// stopper flushes and unmounts a mount, remembering whether everything
+// written to it was persisted.
+type stopper struct {
+	mntPoint string
+	server   *fs.Server
+
+	// stopping is set once stopping begins. It's accessed atomically.
+	stopping int32
+
+	mu      sync.Mutex
+	stopped bool
+	err     error
+}
+
+// stop stops the mount accepting changes, flushes it and unmounts it. The
+// unmount is attempted even if the flush fails, so the mount doesn't dangle.
+func (s *stopper) stop() error {
+	atomic.StoreInt32(&s.stopping, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.server.Shutdown(gocontext.Background())
+	s.stopped, s.err = true, err
+	if err != nil {
+		log.Printf("failed to flush: %v", err)
+	}
+
+	if uerr := fuse.Unmount(s.mntPoint); uerr != nil {
+		return errors.Wrapf(uerr, "failed to unmount %s", s.mntPoint)
+	}
+	return err
+}
+
+// result returns an error if the mount was stopped without persisting all of
+// the data written to it.
+func (s *stopper) result() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped && s.err != nil {
+		return errors.Wrap(s.err, "not all data was persisted")
+	}
+	return nil
+}
+
+// handleSignals stops the mount when the process receives SIGINT or SIGTERM.
+// If unmounting fails, e.g. because the mount is busy, it's retried on the
+// next signal.
+func handleSignals(s *stopper) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	for sig := range sigs {
+		log.Printf("Received %v, flushing and unmounting...", sig)
+		if err := s.stop(); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ehotinger/lightningfs/control"
+	"github.com/urfave/cli"
+)
+
+// Command lists the running mounts.
+var Command = cli.Command{
+	Name:      "status",
+	Usage:     "list running mounts",
+	ArgsUsage: "",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "control-dir",
+			Usage: "The directory of the sockets of running mounts",
+			Value: control.DefaultDir(),
+		},
+	},
+	Action: func(context *cli.Context) error {
+		statuses, err := control.List(context.String("control-dir"))
+		if err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			fmt.Println("No running mounts")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MOUNTPOINT\tCONTAINERS\tUPTIME\tDIRTY\tCACHE\tQUEUE")
+		for _, s := range statuses {
+			if s.Error != "" {
+				fmt.Fprintf(w, "%s\t(unreachable: %s)\t-\t-\t-\t-\n", s.Socket, s.Error)
+				continue
+			}
+			uptime := time.Since(s.Started).Round(time.Second)
+			if s.Unmounting {
+				fmt.Fprintf(w, "%s\t(unmounting)\t%v\t-\t-\t-\n", s.MountPoint, uptime)
+				continue
+			}
+			if s.Busy {
+				fmt.Fprintf(w, "%s\t%s\t%v\t(busy)\t-\t-\n", s.MountPoint, strings.Join(s.Containers, ","), uptime)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t%d\n", s.MountPoint, strings.Join(s.Containers, ","),
+				uptime, formatBytes(s.DirtyBytes), formatBytes(s.CacheBytes), s.UploadQueue)
+		}
+		return w.Flush()
+	},
+}
+
+// formatBytes formats a number of bytes in binary units, e.g. 1.5MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
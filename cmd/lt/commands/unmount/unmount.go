@@ -0,0 +1,35 @@
+package unmount
+
+import (
+	"fmt"
+
+	"github.com/ehotinger/lightningfs/control"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// Command flushes and unmounts a running mount.
+var Command = cli.Command{
+	Name:      "unmount",
+	Usage:     "flush a mount and unmount it",
+	ArgsUsage: "<mountpoint>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "control-dir",
+			Usage: "The directory of the sockets of running mounts",
+			Value: control.DefaultDir(),
+		},
+	},
+	Action: func(context *cli.Context) error {
+		mntPoint := context.Args().First()
+		if mntPoint == "" {
+			return errors.New("mount point is required")
+		}
+
+		if err := control.Unmount(context.String("control-dir"), mntPoint); err != nil {
+			return err
+		}
+		fmt.Printf("Unmounted %s\n", mntPoint)
+		return nil
+	},
+}
@@ -5,7 +5,11 @@ import (
 	"os"
 
 	blobCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/blob"
+	configCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/config"
+	gcCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/gc"
 	mountCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/mount"
+	statusCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/status"
+	unmountCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/unmount"
 	versionCmd "github.com/ehotinger/lightningfs/cmd/lt/commands/version"
 	"github.com/ehotinger/lightningfs/version"
 	"github.com/urfave/cli"
@@ -27,7 +31,11 @@ func New() *cli.App {
 	app.Version = version.Version
 	app.Commands = []cli.Command{
 		blobCmd.Command,
+		configCmd.Command,
+		gcCmd.Command,
 		mountCmd.Command,
+		statusCmd.Command,
+		unmountCmd.Command,
 		versionCmd.Command,
 	}
 	return app
@@ -2,16 +2,125 @@ package config
 
 import (
 	"io/ioutil"
+	"time"
 
+	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
 )
 
+const (
+	// ConflictFail fails the upload with EIO.
+	ConflictFail = "fail"
+
+	// ConflictRename uploads our version alongside theirs as
+	// "<name>.conflict-<host>-<timestamp>".
+	ConflictRename = "rename"
+
+	// ConflictOverwrite replaces their version with ours.
+	ConflictOverwrite = "overwrite"
+)
+
+// Mount is a container, or every container in an account, mounted as a
+// top level directory.
+type Mount struct {
+	// Name is the name of the directory. Defaults to the container name, or
+	// the account name if there's no container.
+	Name string `yaml:"name"`
+
+	Credentials `yaml:",inline"`
+
+	// ContainerName is the container to mount. If it's empty, each of the
+	// account's containers is a subdirectory.
+	ContainerName string `yaml:"containerName"`
+
+	// Prefix is the blob prefix of the container to mount.
+	Prefix string `yaml:"prefix"`
+}
+
 // Config stores configuration details.
 type Config struct {
-	AzureAccountName string `yaml:"accountName"`
-	AzureAccountKey  string `yaml:"accountKey"`
-	CachePath        string `yaml:"cachePath"`
-	ContainerName    string `yaml:"containerName"`
+	Credentials `yaml:",inline"`
+	CachePath   string `yaml:"cachePath"`
+
+	// ContainerName is the container to mount. If it's empty, each of the
+	// account's containers is a directory under the root.
+	ContainerName string `yaml:"containerName"`
+
+	// Mounts mounts several containers or accounts, each as a top level
+	// directory, instead of the account and container above.
+	Mounts []Mount `yaml:"mounts"`
+
+	// StagingPath is where dirty file data is spilled once MemoryBudget is
+	// exhausted. Defaults to CachePath.
+	StagingPath string `yaml:"stagingPath"`
+
+	// MemoryBudget is the number of bytes of file data held in memory across
+	// all files before spilling to StagingPath.
+	MemoryBudget int64 `yaml:"memoryBudget"`
+
+	// PageBlobPatterns are globs of files to store as page blobs, which suit
+	// files that are rewritten in place.
+	PageBlobPatterns []string `yaml:"pageBlobPatterns"`
+
+	// AppendBlobPatterns are globs of files to store as append blobs, which
+	// suit files that are only ever appended to, such as logs.
+	AppendBlobPatterns []string `yaml:"appendBlobPatterns"`
+
+	// CompressPatterns are globs of files to compress with gzip before
+	// they're uploaded. Each block is compressed separately so that reads
+	// only decompress the blocks they need. Only block blobs are compressed.
+	CompressPatterns []string `yaml:"compressPatterns"`
+
+	// ConflictPolicy decides what happens when uploading a file whose blob
+	// was changed by someone else since it was loaded. One of ConflictFail
+	// (the default), ConflictRename or ConflictOverwrite.
+	ConflictPolicy string `yaml:"conflictPolicy"`
+
+	// LeaseWrites takes out a lease on a blob while its file is being written
+	// so that other mounts can't write to it at the same time.
+	LeaseWrites bool `yaml:"leaseWrites"`
+
+	// EncryptionKeyFile is a file holding a base64 encoded 256 bit key. If
+	// it's set, files are encrypted before they're uploaded, each with its
+	// own data key which is stored with the blob, encrypted with this key.
+	// Only block blobs can be encrypted.
+	EncryptionKeyFile string `yaml:"encryptionKeyFile"`
+
+	// Dedup stores files as manifests of content-defined chunks, which are
+	// kept under .chunks at the root of the mount so that duplicate data is
+	// only stored once. Unreferenced chunks are removed by `lt gc`. It can't
+	// be combined with encryption, compression or page and append blobs.
+	Dedup bool `yaml:"dedup"`
+
+	// DefaultTier is the access tier (Hot, Cool or Archive) new files are
+	// stored in. Defaults to the account's default tier.
+	DefaultTier string `yaml:"defaultTier"`
+
+	// AttributeTTL and EntryTTL are how long the kernel may cache attributes
+	// and directory entries before they're checked against the container
+	// again. Zero uses the defaults.
+	AttributeTTL time.Duration `yaml:"attributeTTL"`
+	EntryTTL     time.Duration `yaml:"entryTTL"`
+
+	// NegativeTTL is how long the kernel may cache failed lookups. Zero
+	// disables negative caching.
+	NegativeTTL time.Duration `yaml:"negativeTTL"`
+
+	// PollInterval is how often the container is listed in the background to
+	// pick up changes made by others. Zero disables polling.
+	PollInterval time.Duration `yaml:"pollInterval"`
+
+	// ShutdownTimeout is how long unflushed files are given to upload when
+	// the mount is stopped by a signal or `lt unmount`.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+
+	// Prefix is the blob prefix mounted as the root. Nothing outside of it is
+	// visible.
+	Prefix string `yaml:"prefix"`
+
+	// ReadOnly mounts the container read-only. The data is assumed not to
+	// change, so it's cached aggressively.
+	ReadOnly bool `yaml:"readOnly"`
 }
 
 // NewConfig creates a new Config object.
@@ -21,17 +130,20 @@ func NewConfig(
 	containerName string,
 	cachePath string) *Config {
 	return &Config{
-		AzureAccountName: accountName,
-		AzureAccountKey:  accountKey,
-		ContainerName:    containerName,
-		CachePath:        cachePath,
+		Credentials: Credentials{
+			AzureAccountName: accountName,
+			AzureAccountKey:  accountKey,
+		},
+		ContainerName: containerName,
+		CachePath:     cachePath,
 	}
 }
 
-// NewConfigFromBytes unmarshals a Config from the specified bytes.
+// NewConfigFromBytes unmarshals a Config from the specified bytes, rejecting
+// unknown keys.
 func NewConfigFromBytes(data []byte) (*Config, error) {
 	c := &Config{}
-	err := yaml.Unmarshal(data, c)
+	err := yaml.UnmarshalStrict(data, c)
 	return c, err
 }
 
@@ -43,3 +155,33 @@ func NewConfigFromFile(file string) (*Config, error) {
 	}
 	return NewConfigFromBytes(data)
 }
+
+// ResolveCredentials fills in the credentials of the account and of each
+// mount which weren't given explicitly. Secrets from the environment which
+// don't name a mount's account are only used if there's a single mount.
+func (c *Config) ResolveCredentials() error {
+	if err := c.Credentials.resolve(len(c.Mounts) == 0); err != nil {
+		return err
+	}
+	for i := range c.Mounts {
+		if err := c.Mounts[i].resolve(len(c.Mounts) == 1); err != nil {
+			return errors.Wrapf(err, "failed to resolve credentials of mount %d", i)
+		}
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with the secrets hidden, for display.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Credentials = c.Credentials.Redacted()
+	if c.Mounts == nil {
+		return &r
+	}
+	r.Mounts = make([]Mount, len(c.Mounts))
+	for i, m := range c.Mounts {
+		m.Credentials = m.Credentials.Redacted()
+		r.Mounts[i] = m
+	}
+	return &r
+}
@@ -64,3 +64,23 @@ func TestNewConfigFromFile(t *testing.T) {
 		}
 	}
 }
+
+func TestNewConfigFromFileMounts(t *testing.T) {
+	actual, err := NewConfigFromFile("testdata/mounts.yaml")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := []Mount{
+		{Credentials: Credentials{AzureAccountName: "a", AzureAccountKey: "b"}, ContainerName: "c", Prefix: "datasets/imagenet"},
+		{Name: "archive", Credentials: Credentials{AzureAccountName: "e", AzureAccountKey: "f"}},
+	}
+	if len(actual.Mounts) != len(expected) {
+		t.Fatalf("expected %d mounts but got %d", len(expected), len(actual.Mounts))
+	}
+	for i, m := range expected {
+		if actual.Mounts[i] != m {
+			t.Fatalf("expected %+v but got %+v for mount %d", m, actual.Mounts[i], i)
+		}
+	}
+}
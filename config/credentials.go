@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The standard environment variables credentials are read from.
+const (
+	EnvAccountName      = "AZURE_STORAGE_ACCOUNT"
+	EnvAccountKey       = "AZURE_STORAGE_KEY"
+	EnvSASToken         = "AZURE_STORAGE_SAS_TOKEN"
+	EnvConnectionString = "AZURE_STORAGE_CONNECTION_STRING"
+)
+
+const redacted = "REDACTED"
+
+// Credentials identify an account and authorize requests to it.
+type Credentials struct {
+	AzureAccountName string `yaml:"accountName"`
+	AzureAccountKey  string `yaml:"accountKey"`
+
+	// SASToken authorizes requests with a shared access signature instead of
+	// the account key.
+	SASToken string `yaml:"sasToken"`
+
+	// ConnectionString gives the account, its endpoint and its key or SAS
+	// token together, in the format the Azure portal shows.
+	ConnectionString string `yaml:"connectionString"`
+
+	// AccountKeyFile, SASTokenFile and ConnectionStringFile are files the
+	// secrets above are read from, so that they needn't be kept in the
+	// config or on the command line.
+	AccountKeyFile       string `yaml:"accountKeyFile"`
+	SASTokenFile         string `yaml:"sasTokenFile"`
+	ConnectionStringFile string `yaml:"connectionStringFile"`
+
+	// BlobEndpoint is the URL of the account's blob service. Defaults to the
+	// one derived from the account name.
+	BlobEndpoint string `yaml:"blobEndpoint"`
+
+	// OAuth authorizes requests with tokens instead of the account key.
+	OAuth *OAuth `yaml:"oauth"`
+
+	// ManagedIdentity authorizes requests with the VM's managed identity
+	// instead of the account key.
+	ManagedIdentity *ManagedIdentity `yaml:"managedIdentity"`
+}
+
+// OAuth configures authorizing requests with Azure AD tokens, acquired with
+// the client credentials flow, instead of an account key.
+type OAuth struct {
+	TenantID     string `yaml:"tenantId"`
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+
+	// AuthorityURL is where tokens are requested from. Defaults to Azure AD.
+	AuthorityURL string `yaml:"authorityUrl"`
+}
+
+// ManagedIdentity configures authorizing requests with tokens for the
+// managed identity of the Azure VM the mount runs on, from its instance
+// metadata service.
+type ManagedIdentity struct {
+	// ClientID selects a user assigned identity. Defaults to the system
+	// assigned identity.
+	ClientID string `yaml:"clientId"`
+
+	// Endpoint is where tokens are requested from. Defaults to the instance
+	// metadata service.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Resolve fills in the credentials which weren't given explicitly: first from
+// the secret files, then from the connection string, and finally from the
+// standard environment variables. It's for credentials which are the only
+// ones in use, so the environment is used even if it doesn't name an account.
+func (c *Credentials) Resolve() error {
+	return c.resolve(true)
+}
+
+// resolve is Resolve, except that the environment is only used for other
+// credentials if it's for the same account, unless implicit is set: its
+// secrets would otherwise be handed to every account.
+func (c *Credentials) resolve(implicit bool) error {
+	for _, secret := range []struct {
+		file  string
+		value *string
+	}{
+		{c.AccountKeyFile, &c.AzureAccountKey},
+		{c.SASTokenFile, &c.SASToken},
+		{c.ConnectionStringFile, &c.ConnectionString},
+	} {
+		if secret.file == "" || *secret.value != "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(secret.file)
+		if err != nil {
+			return errors.Wrap(err, "failed to read secret")
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+
+	if c.ConnectionString != "" {
+		parsed, err := ParseConnectionString(c.ConnectionString)
+		if err != nil {
+			return err
+		}
+		c.merge(parsed)
+	}
+	if s := os.Getenv(EnvConnectionString); s != "" {
+		parsed, err := ParseConnectionString(s)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvConnectionString)
+		}
+		c.mergeEnv(parsed, implicit)
+	}
+	c.mergeEnv(Credentials{
+		AzureAccountName: os.Getenv(EnvAccountName),
+		AzureAccountKey:  os.Getenv(EnvAccountKey),
+		SASToken:         os.Getenv(EnvSASToken),
+	}, implicit)
+	return nil
+}
+
+// mergeEnv merges credentials from the environment, if they're for the same
+// account as c or implicit is set.
+func (c *Credentials) mergeEnv(o Credentials, implicit bool) {
+	if implicit || (c.AzureAccountName != "" && c.AzureAccountName == o.AzureAccountName) {
+		c.merge(o)
+	}
+}
+
+// HasSecret reports whether there's anything to authorize requests with.
+func (c *Credentials) HasSecret() bool {
+	return c.AzureAccountKey != "" || c.SASToken != "" || c.OAuth != nil || c.ManagedIdentity != nil
+}
+
+// Redacted returns a copy of the credentials with the secrets hidden, for
+// display.
+func (c Credentials) Redacted() Credentials {
+	for _, secret := range []*string{&c.AzureAccountKey, &c.SASToken, &c.ConnectionString} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	if c.OAuth != nil {
+		oauth := *c.OAuth
+		if oauth.ClientSecret != "" {
+			oauth.ClientSecret = redacted
+		}
+		c.OAuth = &oauth
+	}
+	return c
+}
+
+// merge fills in the empty fields of c from o, unless they're for different
+// accounts. Secrets are only taken if c doesn't have one already.
+func (c *Credentials) merge(o Credentials) {
+	if c.AzureAccountName != "" && o.AzureAccountName != "" && c.AzureAccountName != o.AzureAccountName {
+		return
+	}
+	if c.AzureAccountName == "" {
+		c.AzureAccountName = o.AzureAccountName
+	}
+	if c.BlobEndpoint == "" {
+		c.BlobEndpoint = o.BlobEndpoint
+	}
+	if !c.HasSecret() {
+		c.AzureAccountKey = o.AzureAccountKey
+		c.SASToken = o.SASToken
+	}
+}
+
+// ParseConnectionString parses an Azure Storage connection string, such as
+// "DefaultEndpointsProtocol=https;AccountName=...;AccountKey=...".
+func ParseConnectionString(s string) (Credentials, error) {
+	var (
+		c        Credentials
+		protocol = "https"
+		suffix   = "core.windows.net"
+	)
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return c, fmt.Errorf("malformed connection string setting: %q", kv[0])
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch strings.ToLower(key) {
+		case "defaultendpointsprotocol":
+			protocol = value
+		case "accountname":
+			c.AzureAccountName = value
+		case "accountkey":
+			c.AzureAccountKey = value
+		case "sharedaccesssignature":
+			c.SASToken = value
+		case "blobendpoint":
+			c.BlobEndpoint = value
+		case "endpointsuffix":
+			suffix = value
+		}
+	}
+
+	if c.BlobEndpoint == "" {
+		if c.AzureAccountName == "" {
+			return c, errors.New("connection string has neither an account name nor a blob endpoint")
+		}
+		c.BlobEndpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, c.AzureAccountName, suffix)
+	}
+	return c, nil
+}
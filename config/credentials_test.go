@@ -0,0 +1,153 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseConnectionString(t *testing.T) {
+	for _, test := range []struct {
+		s           string
+		expected    Credentials
+		shouldError bool
+	}{
+		{
+			"DefaultEndpointsProtocol=https;AccountName=a;AccountKey=a2V5==;EndpointSuffix=core.windows.net",
+			Credentials{AzureAccountName: "a", AzureAccountKey: "a2V5==", BlobEndpoint: "https://a.blob.core.windows.net"},
+			false,
+		},
+		{
+			"BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;SharedAccessSignature=sv=2018-03-28&sig=abc%3D;",
+			Credentials{BlobEndpoint: "http://127.0.0.1:10000/devstoreaccount1", SASToken: "sv=2018-03-28&sig=abc%3D"},
+			false,
+		},
+		{"AccountName=a;AccountKey=b;EndpointSuffix=core.chinacloudapi.cn", Credentials{AzureAccountName: "a", AzureAccountKey: "b", BlobEndpoint: "https://a.blob.core.chinacloudapi.cn"}, false},
+		{"AccountKey=b", Credentials{}, true},
+		{"AccountName", Credentials{}, true},
+	} {
+		actual, err := ParseConnectionString(test.s)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatalf("expected %q to error, but it didn't", test.s)
+		}
+		if actual != test.expected {
+			t.Fatalf("expected %+v but got %+v", test.expected, actual)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for _, test := range []struct {
+		env      map[string]string
+		given    Credentials
+		expected Credentials
+	}{
+		{
+			map[string]string{EnvAccountName: "a", EnvAccountKey: "b"},
+			Credentials{},
+			Credentials{AzureAccountName: "a", AzureAccountKey: "b"},
+		},
+		{
+			// The environment is for another account.
+			map[string]string{EnvAccountName: "a", EnvAccountKey: "b"},
+			Credentials{AzureAccountName: "c"},
+			Credentials{AzureAccountName: "c"},
+		},
+		{
+			// Explicit secrets take precedence.
+			map[string]string{EnvSASToken: "sv=1"},
+			Credentials{AzureAccountName: "a", AccountKeyFile: "testdata/accountkey"},
+			Credentials{AzureAccountName: "a", AzureAccountKey: "c2VjcmV0", AccountKeyFile: "testdata/accountkey"},
+		},
+		{
+			map[string]string{EnvConnectionString: "AccountName=a;SharedAccessSignature=sv=1"},
+			Credentials{},
+			Credentials{AzureAccountName: "a", SASToken: "sv=1", BlobEndpoint: "https://a.blob.core.windows.net"},
+		},
+	} {
+		for _, key := range []string{EnvAccountName, EnvAccountKey, EnvSASToken, EnvConnectionString} {
+			os.Setenv(key, test.env[key])
+		}
+		actual := test.given
+		if err := actual.Resolve(); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if actual != test.expected {
+			t.Fatalf("expected %+v but got %+v", test.expected, actual)
+		}
+	}
+	for _, key := range []string{EnvAccountName, EnvAccountKey, EnvSASToken, EnvConnectionString} {
+		os.Unsetenv(key)
+	}
+
+	c := Credentials{SASTokenFile: "testdata/missing"}
+	if err := c.Resolve(); err == nil {
+		t.Fatal("expected a missing secret file to error, but it didn't")
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	var (
+		a = Mount{Credentials: Credentials{AzureAccountName: "a"}, ContainerName: "c"}
+		b = Mount{Credentials: Credentials{AzureAccountName: "b"}, ContainerName: "c"}
+	)
+	for _, test := range []struct {
+		env      map[string]string
+		given    Config
+		expected []string
+	}{
+		{
+			map[string]string{EnvAccountName: "a", EnvAccountKey: "a2V5"},
+			Config{Mounts: []Mount{a, b}},
+			[]string{"a2V5", ""},
+		},
+		{
+			// Only a single mount takes secrets which don't name its account.
+			map[string]string{EnvAccountKey: "a2V5"},
+			Config{Mounts: []Mount{a, b}},
+			[]string{"", ""},
+		},
+		{
+			map[string]string{EnvAccountKey: "a2V5"},
+			Config{Mounts: []Mount{b}},
+			[]string{"a2V5"},
+		},
+		{
+			map[string]string{EnvConnectionString: "BlobEndpoint=http://127.0.0.1:10000/a;SharedAccessSignature=sv=1"},
+			Config{Mounts: []Mount{a, b}},
+			[]string{"", ""},
+		},
+		{
+			map[string]string{EnvAccountKey: "a2V5"},
+			Config{Credentials: Credentials{AzureAccountName: "a"}},
+			[]string{"a2V5"},
+		},
+	} {
+		for _, key := range []string{EnvAccountName, EnvAccountKey, EnvSASToken, EnvConnectionString} {
+			os.Setenv(key, test.env[key])
+		}
+		c := test.given
+		c.Mounts = append([]Mount(nil), test.given.Mounts...)
+		if err := c.ResolveCredentials(); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		var actual []string
+		for _, m := range c.Mounts {
+			actual = append(actual, m.AzureAccountKey+m.SASToken)
+		}
+		if len(c.Mounts) == 0 {
+			actual = append(actual, c.AzureAccountKey+c.SASToken)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("expected secrets %q but got %q", test.expected, actual)
+		}
+	}
+	for _, key := range []string{EnvAccountName, EnvAccountKey, EnvSASToken, EnvConnectionString} {
+		os.Unsetenv(key)
+	}
+}
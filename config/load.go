@@ -0,0 +1,249 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ehotinger/lightningfs/defaults"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EnvPrefix is the prefix of the environment variables settings are read
+	// from. The rest of the name is the setting's key in upper snake case,
+	// e.g. LIGHTNINGFS_CACHE_PATH for cachePath and LIGHTNINGFS_OAUTH_CLIENT_ID
+	// for oauth.clientId.
+	EnvPrefix = "LIGHTNINGFS_"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load builds a Config from, in increasing order of precedence, the built-in
+// defaults, the config file if there is one, LIGHTNINGFS_ environment
+// variables and overrides, which map setting keys to values, e.g. from the
+// command line. The credentials are then resolved and the result validated.
+func Load(file string, overrides map[string]string) (*Config, error) {
+	c := &Config{}
+	if file != "" {
+		var err error
+		if c, err = NewConfigFromFile(file); err != nil {
+			return nil, errors.Wrapf(err, "failed to load %s", file)
+		}
+	}
+
+	for _, key := range Keys() {
+		if value, ok := os.LookupEnv(EnvName(key)); ok {
+			if err := c.Set(key, value); err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", EnvName(key))
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.Set(key, overrides[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	c.SetDefaults()
+	if err := c.ResolveCredentials(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetDefaults fills in the settings which weren't given with their defaults.
+func (c *Config) SetDefaults() {
+	if c.StagingPath == "" {
+		c.StagingPath = c.CachePath
+	}
+	if c.MemoryBudget == 0 {
+		c.MemoryBudget = defaults.MemoryBudget
+	}
+	if c.ConflictPolicy == "" {
+		c.ConflictPolicy = ConflictFail
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = defaults.ShutdownTimeout
+	}
+
+	attributeTTL, entryTTL := defaults.AttributeTTL, defaults.EntryTTL
+	if c.ReadOnly {
+		attributeTTL, entryTTL = defaults.ReadOnlyTTL, defaults.ReadOnlyTTL
+	}
+	if c.AttributeTTL == 0 {
+		c.AttributeTTL = attributeTTL
+	}
+	if c.EntryTTL == 0 {
+		c.EntryTTL = entryTTL
+	}
+}
+
+// Keys returns the keys of the settings which can be given individually, as
+// dotted paths of their YAML keys.
+func Keys() []string {
+	return keys(reflect.TypeOf(Config{}), "")
+}
+
+func keys(t reflect.Type, prefix string) []string {
+	var result []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, inline := yamlName(f)
+		switch {
+		case inline:
+			result = append(result, keys(f.Type, prefix)...)
+		case name == "-":
+		case f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct:
+			// Pointers to structs can be enabled as a whole.
+			result = append(result, prefix+name)
+			result = append(result, keys(f.Type.Elem(), prefix+name+".")...)
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+			// Lists of structs, like mounts, can only be given in the file.
+		default:
+			result = append(result, prefix+name)
+		}
+	}
+	return result
+}
+
+// yamlName returns the YAML key of a field, and whether it's inlined.
+func yamlName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("yaml")
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			return "", true
+		}
+	}
+	if parts[0] == "" {
+		return strings.ToLower(f.Name), false
+	}
+	return parts[0], false
+}
+
+// EnvName returns the environment variable a setting is read from.
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	runes := []rune(key)
+	for i, r := range runes {
+		switch {
+		case r == '.':
+			b.WriteRune('_')
+			continue
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]):
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Set sets a setting from its string form, as given in an environment
+// variable or on the command line. Lists are comma separated. Setting a
+// section, such as oauth, to true or false enables or disables it.
+func (c *Config) Set(key string, value string) error {
+	v, ok := lookUpField(reflect.ValueOf(c).Elem(), strings.Split(key, "."))
+	if !ok {
+		return fmt.Errorf("unknown setting: %q", key)
+	}
+	if err := setField(v, value); err != nil {
+		return errors.Wrapf(err, "invalid %s", key)
+	}
+	return nil
+}
+
+// lookUpField returns the field at a path of YAML keys, allocating any
+// sections along the way.
+func lookUpField(v reflect.Value, path []string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, inline := yamlName(t.Field(i))
+		field := v.Field(i)
+		if inline {
+			if found, ok := lookUpField(field, path); ok {
+				return found, true
+			}
+			continue
+		}
+		if name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return field, true
+		}
+		if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			field = field.Elem()
+		}
+		if field.Kind() != reflect.Struct {
+			break
+		}
+		return lookUpField(field, path[1:])
+	}
+	return reflect.Value{}, false
+}
+
+func setField(v reflect.Value, value string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !enabled:
+			v.Set(reflect.Zero(v.Type()))
+		case v.IsNil():
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+	default:
+		return errors.New("it can't be set from a string")
+	}
+	return nil
+}
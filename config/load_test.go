@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	for _, test := range []struct {
+		key      string
+		expected string
+	}{
+		{"cachePath", "LIGHTNINGFS_CACHE_PATH"},
+		{"attributeTTL", "LIGHTNINGFS_ATTRIBUTE_TTL"},
+		{"oauth.clientId", "LIGHTNINGFS_OAUTH_CLIENT_ID"},
+		{"prefix", "LIGHTNINGFS_PREFIX"},
+	} {
+		if actual := EnvName(test.key); actual != test.expected {
+			t.Fatalf("expected %s but got %s for %s", test.expected, actual, test.key)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, test := range []struct {
+		key         string
+		value       string
+		shouldError bool
+	}{
+		{"accountName", "a", false},
+		{"memoryBudget", "1024", false},
+		{"pollInterval", "30s", false},
+		{"readOnly", "true", false},
+		{"compressPatterns", "*.csv, *.json", false},
+		{"oauth.clientId", "id", false},
+		{"managedIdentity", "true", false},
+		{"memoryBudget", "lots", true},
+		{"pollInterval", "30", true},
+		{"cachPath", "d", true},
+		{"mounts", "a", true},
+		{"oauth.missing", "a", true},
+	} {
+		c := &Config{}
+		err := c.Set(test.key, test.value)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatalf("expected setting %s to %q to error, but it didn't", test.key, test.value)
+		}
+	}
+
+	c := &Config{}
+	c.Set("compressPatterns", "*.csv, *.json")
+	c.Set("oauth.clientId", "id")
+	c.Set("pollInterval", "30s")
+	if !reflect.DeepEqual(c.CompressPatterns, []string{"*.csv", "*.json"}) || c.OAuth == nil || c.OAuth.ClientID != "id" || c.PollInterval != 30*time.Second {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	os.Setenv("LIGHTNINGFS_CONTAINER_NAME", "env")
+	os.Setenv("LIGHTNINGFS_READ_ONLY", "true")
+	defer os.Unsetenv("LIGHTNINGFS_CONTAINER_NAME")
+	defer os.Unsetenv("LIGHTNINGFS_READ_ONLY")
+
+	// Flags take precedence over the environment, which takes precedence over
+	// the file.
+	actual, err := Load("testdata/config.yaml", map[string]string{"readOnly": "false", "pollInterval": "1m"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if actual.AzureAccountName != "a" || actual.ContainerName != "env" || actual.ReadOnly || actual.PollInterval != time.Minute {
+		t.Fatalf("unexpected config: %+v", actual)
+	}
+	if actual.StagingPath != "d" || actual.ConflictPolicy != ConflictFail {
+		t.Fatalf("expected defaults to be filled in: %+v", actual)
+	}
+
+	for _, test := range []struct {
+		file      string
+		overrides map[string]string
+		message   string
+	}{
+		{"testdata/unknown.yaml", nil, "cachPath"},
+		{"testdata/config.yaml", map[string]string{"conflictPolicy": "merge"}, "conflictPolicy"},
+		{"testdata/config.yaml", map[string]string{"compressPatterns": "["}, "compressPatterns"},
+		{"testdata/config.yaml", map[string]string{"dedup": "true", "encryptionKeyFile": "key"}, "dedup"},
+		{"testdata/config.yaml", map[string]string{"containerName": "", "prefix": "data"}, "prefix"},
+	} {
+		_, err := Load(test.file, test.overrides)
+		if err == nil {
+			t.Fatalf("expected %v to error, but it didn't", test.overrides)
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Fatalf("expected error mentioning %s but got: %v", test.message, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := &Config{Credentials: Credentials{AzureAccountName: "a", AzureAccountKey: "b", OAuth: &OAuth{ClientSecret: "c"}}}
+	r := c.Redacted()
+	if r.AzureAccountName != "a" || r.AzureAccountKey != redacted || r.OAuth.ClientSecret != redacted {
+		t.Fatalf("unexpected redacted config: %+v", r)
+	}
+	if c.AzureAccountKey != "b" || c.OAuth.ClientSecret != "c" {
+		t.Fatal("redacting modified the original")
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ValidationError lists the problems found with a Config.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks that the settings are valid and consistent, returning a
+// ValidationError listing every problem found.
+func (c *Config) Validate() error {
+	var v validator
+
+	switch c.ConflictPolicy {
+	case "", ConflictFail, ConflictRename, ConflictOverwrite:
+	default:
+		v.addf("conflictPolicy: must be %s, %s or %s, not %q", ConflictFail, ConflictRename, ConflictOverwrite, c.ConflictPolicy)
+	}
+	switch strings.ToLower(c.DefaultTier) {
+	case "", "none", "hot", "cool", "archive":
+	default:
+		v.addf("defaultTier: must be Hot, Cool or Archive, not %q", c.DefaultTier)
+	}
+
+	v.patterns("pageBlobPatterns", c.PageBlobPatterns)
+	v.patterns("appendBlobPatterns", c.AppendBlobPatterns)
+	v.patterns("compressPatterns", c.CompressPatterns)
+
+	if c.MemoryBudget < 0 {
+		v.addf("memoryBudget: must not be negative")
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"attributeTTL", c.AttributeTTL},
+		{"entryTTL", c.EntryTTL},
+		{"negativeTTL", c.NegativeTTL},
+		{"pollInterval", c.PollInterval},
+		{"shutdownTimeout", c.ShutdownTimeout},
+	} {
+		if d.value < 0 {
+			v.addf("%s: must not be negative", d.key)
+		}
+	}
+
+	paged := len(c.PageBlobPatterns) > 0 || len(c.AppendBlobPatterns) > 0
+	if c.EncryptionKeyFile != "" {
+		if paged {
+			v.addf("encryptionKeyFile: page and append blobs can't be encrypted")
+		}
+		if len(c.CompressPatterns) > 0 {
+			v.addf("encryptionKeyFile: compressed files can't be encrypted")
+		}
+	}
+	if c.Dedup && (paged || len(c.CompressPatterns) > 0 || c.EncryptionKeyFile != "") {
+		v.addf("dedup: can't be combined with page or append blobs, compression or encryption")
+	}
+
+	if len(c.Mounts) == 0 {
+		v.mount("", Mount{Credentials: c.Credentials, ContainerName: c.ContainerName, Prefix: c.Prefix})
+		return v.err()
+	}
+	if c.ContainerName != "" || c.Prefix != "" {
+		v.addf("mounts: containerName and prefix go in each mount instead")
+	}
+	names := make(map[string]bool)
+	for i, m := range c.Mounts {
+		key := fmt.Sprintf("mounts[%d].", i)
+		v.mount(key, m)
+
+		name := m.Name
+		switch {
+		case name == "" && m.ContainerName != "":
+			name = m.ContainerName
+		case name == "":
+			name = m.AzureAccountName
+		}
+		switch {
+		case name == "" || strings.Contains(name, "/"):
+			v.addf("%sname: invalid mount name %q", key, name)
+		case names[name]:
+			v.addf("%sname: duplicate mount name %q", key, name)
+		}
+		names[name] = true
+	}
+	return v.err()
+}
+
+// validator collects problems.
+type validator ValidationError
+
+func (v *validator) addf(format string, args ...interface{}) {
+	*v = append(*v, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) err() error {
+	if len(*v) == 0 {
+		return nil
+	}
+	return ValidationError(*v)
+}
+
+func (v *validator) patterns(key string, patterns []string) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.addf("%s: invalid pattern %q: %v", key, pattern, err)
+		}
+	}
+}
+
+// mount checks a mount, with its keys prefixed by key.
+func (v *validator) mount(key string, m Mount) {
+	if m.AzureAccountName == "" && m.BlobEndpoint == "" {
+		v.addf("%saccountName: required", key)
+	}
+	if !m.HasSecret() {
+		v.addf("%saccountKey: required, unless a SAS token, OAuth or a managed identity is configured", key)
+	}
+	if m.OAuth != nil && m.ManagedIdentity != nil {
+		v.addf("%soauth: can't be combined with managedIdentity", key)
+	}
+	if o := m.OAuth; o != nil && (o.TenantID == "" || o.ClientID == "" || o.ClientSecret == "") {
+		v.addf("%soauth: tenantId, clientId and clientSecret are required", key)
+	}
+
+	prefix := strings.Trim(m.Prefix, "/")
+	switch {
+	case prefix == "":
+	case m.ContainerName == "":
+		v.addf("%sprefix: requires a containerName", key)
+	case path.Clean(prefix) != prefix || prefix == ".." || strings.HasPrefix(prefix, "../"):
+		v.addf("%sprefix: invalid prefix %q", key, m.Prefix)
+	}
+}
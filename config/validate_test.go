@@ -0,0 +1,42 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	key := Credentials{AzureAccountName: "a", AzureAccountKey: "a2V5"}
+	for _, test := range []struct {
+		config   *Config
+		expected []string
+	}{
+		{NewConfig("a", "a2V5", "c", ""), nil},
+		{NewConfig("a", "", "c", ""), []string{"accountKey: required"}},
+		{&Config{Credentials: key, ConflictPolicy: "merge", PollInterval: -1}, []string{"conflictPolicy", "pollInterval"}},
+		{&Config{Mounts: []Mount{{Credentials: key, ContainerName: "c"}, {Credentials: key, Name: "d"}}}, nil},
+		{&Config{Mounts: []Mount{
+			{Credentials: key, ContainerName: "c"},
+			{Credentials: Credentials{AzureAccountName: "b"}, ContainerName: "d"},
+			{Credentials: Credentials{SASToken: "sig=x"}, ContainerName: "e"},
+		}}, []string{"mounts[1].accountKey: required", "mounts[2].accountName: required"}},
+		{&Config{Mounts: []Mount{{Credentials: key, ContainerName: "c"}, {Credentials: key, ContainerName: "c"}}}, []string{"mounts[1].name: duplicate"}},
+	} {
+		err := test.config.Validate()
+		if test.expected == nil {
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			continue
+		}
+		verr, ok := err.(ValidationError)
+		if !ok || len(verr) != len(test.expected) {
+			t.Fatalf("expected %d problems but got %v", len(test.expected), err)
+		}
+		for i, problem := range test.expected {
+			if !strings.HasPrefix(verr[i], problem) {
+				t.Fatalf("expected %q but got %q", problem, verr[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// statusTimeout is how long a mount has to report its status.
+const statusTimeout = 5 * time.Second
+
+// GetStatus returns the status of the mount at mntPoint.
+func GetStatus(dir string, mntPoint string) (Status, error) {
+	socket, err := SocketPath(dir, mntPoint)
+	if err != nil {
+		return Status{}, err
+	}
+	status, err := getStatus(socket)
+	if err != nil {
+		return status, errors.Wrapf(err, "failed to reach the mount at %s", mntPoint)
+	}
+	return status, nil
+}
+
+// List returns the status of every running mount, ordered by mount point.
+// Mounts which don't respond are listed with an error. Sockets left behind by
+// mounts which didn't exit cleanly are removed.
+func List(dir string) ([]Status, error) {
+	sockets, err := filepath.Glob(filepath.Join(dir, "*"+socketExt))
+	if err != nil {
+		return nil, err
+	}
+
+	// Mounts are asked at once, so that slow ones don't hold up the rest.
+	statuses := make([]Status, len(sockets))
+	errs := make([]error, len(sockets))
+	var wg sync.WaitGroup
+	for i, socket := range sockets {
+		wg.Add(1)
+		go func(i int, socket string) {
+			defer wg.Done()
+			statuses[i], errs[i] = getStatus(socket)
+		}(i, socket)
+	}
+	wg.Wait()
+
+	var listed []Status
+	for i, socket := range sockets {
+		switch err := errs[i]; {
+		case isRefused(err):
+			os.Remove(socket)
+		case err != nil:
+			listed = append(listed, Status{Socket: socket, Error: err.Error()})
+		default:
+			listed = append(listed, statuses[i])
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		if listed[i].MountPoint != listed[j].MountPoint {
+			return listed[i].MountPoint < listed[j].MountPoint
+		}
+		return listed[i].Socket < listed[j].Socket
+	})
+	return listed, nil
+}
+
+// Unmount asks the mount at mntPoint to flush and unmount, waiting until it
+// has. It returns an error if not all of the data written to the mount could
+// be persisted.
+func Unmount(dir string, mntPoint string) error {
+	socket, err := SocketPath(dir, mntPoint)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return fmt.Errorf("there's no lightningfs mount at %s", mntPoint)
+	}
+
+	// Flushing can take as long as the mount's shutdown timeout.
+	resp, err := client(socket, 0).Post("http://unix/unmount", "", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reach the mount at %s", mntPoint)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func getStatus(socket string) (Status, error) {
+	var status Status
+	resp, err := client(socket, statusTimeout).Get("http://unix/status")
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return status, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+// client returns an HTTP client which connects to socket.
+func client(socket string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// checkResponse returns the error a mount responded with, if any.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return errors.New(msg)
+	}
+	return errors.New(resp.Status)
+}
+
+// isRefused reports whether err is from connecting to a socket nobody is
+// listening on.
+func isRefused(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if oerr, ok := err.(*net.OpError); ok {
+		err = oerr.Err
+	}
+	if serr, ok := err.(*os.SyscallError); ok {
+		err = serr.Err
+	}
+	return err == syscall.ECONNREFUSED || err == syscall.ENOENT
+}
@@ -0,0 +1,147 @@
+// Package control lets lt talk to running mounts over a unix socket per
+// mount, to report their status and to unmount them gracefully.
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ehotinger/lightningfs/defaults"
+	"github.com/pkg/errors"
+)
+
+const socketExt = ".sock"
+
+// Status describes a running mount.
+type Status struct {
+	MountPoint string    `json:"mountPoint"`
+	PID        int       `json:"pid"`
+	Started    time.Time `json:"started"`
+
+	// Unmounting is set while the mount is being flushed and unmounted, when
+	// the rest of the status isn't reported.
+	Unmounting bool `json:"unmounting"`
+
+	// Containers are the containers mounted, as account/container/prefix.
+	Containers []string `json:"containers"`
+
+	// DirtyBytes is the data written but not yet uploaded, and UploadQueue
+	// the number of files it's in.
+	DirtyBytes  int64 `json:"dirtyBytes"`
+	UploadQueue int   `json:"uploadQueue"`
+
+	// CacheBytes is the file data held in memory or the staging path.
+	CacheBytes int64 `json:"cacheBytes"`
+
+	// Busy is set if the mount was too busy to count its files, when
+	// DirtyBytes, UploadQueue and CacheBytes aren't reported.
+	Busy bool `json:"busy"`
+
+	// Socket and Error are set by List, in place of the rest of the status,
+	// for a mount which didn't respond.
+	Socket string `json:"-"`
+	Error  string `json:"-"`
+}
+
+// Mount is a running mount which can be controlled.
+type Mount interface {
+	Status() Status
+
+	// Unmount flushes the mount and unmounts it, returning an error if not
+	// all of the data written to it could be persisted.
+	Unmount() error
+}
+
+// DefaultDir returns the directory control sockets are in by default: under
+// $XDG_RUNTIME_DIR if it's set, since only its user can reach it.
+func DefaultDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "lightningfs")
+	}
+	return defaults.ControlDir
+}
+
+// SocketPath returns the control socket of the mount at mntPoint.
+func SocketPath(dir string, mntPoint string) (string, error) {
+	abs, err := filepath.Abs(mntPoint)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %s", mntPoint)
+	}
+	// Mount points can be too long for a socket name, so use their hash.
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+socketExt), nil
+}
+
+// Listen creates the control socket of the mount at mntPoint, replacing any
+// left behind by a mount which didn't exit cleanly.
+func Listen(dir string, mntPoint string) (net.Listener, error) {
+	socket, err := SocketPath(dir, mntPoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s", dir)
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+	if _, err := getStatus(socket); err == nil {
+		return nil, errors.Errorf("%s is already mounted", mntPoint)
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to remove stale socket %s", socket)
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", socket)
+	}
+	return l, nil
+}
+
+// checkDir checks that dir is a directory only the current user can reach, so
+// that nobody else can listen in place of a mount or ask it to unmount.
+func checkDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("%s isn't a directory", dir)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() {
+		return errors.Errorf("%s isn't owned by the current user", dir)
+	}
+	if perm := fi.Mode().Perm(); perm != 0700 {
+		return errors.Errorf("%s has mode %#o, not 0700", dir, perm)
+	}
+	return nil
+}
+
+// NewServer returns a server answering requests for m. It's started with
+// Serve and stopped with Shutdown, which waits for an unmount in progress to
+// respond.
+func NewServer(m Mount) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Status())
+	})
+	mux.HandleFunc("/unmount", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unmount must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := m.Unmount(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return &http.Server{Handler: mux}
+}
@@ -0,0 +1,130 @@
+package control
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+type fakeMount struct {
+	status    Status
+	err       error
+	unmounted bool
+}
+
+func (m *fakeMount) Status() Status {
+	return m.status
+}
+
+func (m *fakeMount) Unmount() error {
+	m.unmounted = true
+	return m.err
+}
+
+func TestControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lt")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A socket nobody is listening on is stale.
+	stale, err := SocketPath(dir, "/mnt/stale")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	mounts := map[string]*fakeMount{
+		"/mnt/a": {status: Status{MountPoint: "/mnt/a", Containers: []string{"account/a"}, DirtyBytes: 10, UploadQueue: 1}},
+		"/mnt/b": {status: Status{MountPoint: "/mnt/b", Started: time.Now().UTC().Round(time.Second)}, err: errors.New("1 files couldn't be flushed")},
+	}
+	for mntPoint, m := range mounts {
+		l, err := Listen(dir, mntPoint)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer l.Close()
+		go NewServer(m).Serve(l)
+	}
+
+	if _, err := Listen(dir, "/mnt/a"); err == nil {
+		t.Fatal("expected listening on a mount point in use to error, but it didn't")
+	}
+
+	// A mount which fails to report its status is still listed.
+	broken, err := Listen(dir, "/mnt/broken")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer broken.Close()
+	go http.Serve(broken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "stuck", http.StatusServiceUnavailable)
+	}))
+
+	statuses, err := List(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(statuses) != 3 || statuses[0].Error != "stuck" || statuses[1].MountPoint != "/mnt/a" || statuses[1].DirtyBytes != 10 || !statuses[2].Started.Equal(mounts["/mnt/b"].status.Started) {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale socket to be removed, but got %v", err)
+	}
+
+	for _, test := range []struct {
+		mntPoint    string
+		shouldError bool
+	}{
+		{"/mnt/a", false},
+		{"/mnt/b", true},
+		{"/mnt/c", true},
+	} {
+		err := Unmount(dir, test.mntPoint)
+		if m, ok := mounts[test.mntPoint]; ok && !m.unmounted {
+			t.Fatalf("expected %s to be unmounted", test.mntPoint)
+		}
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatal("expected test to error, but it didn't")
+		}
+	}
+}
+
+func TestListenDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lt")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Others could replace the sockets in a directory they can write to.
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if _, err := Listen(dir, "/mnt/a"); err == nil {
+		t.Fatal("expected listening in a directory others can reach to error, but it didn't")
+	}
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	l, err := Listen(dir, "/mnt/a")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	l.Close()
+}
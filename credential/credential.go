@@ -0,0 +1,71 @@
+// Package credential provides the credentials requests to the blob service
+// are authorized with.
+package credential
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceFmt = "https://%s.blob.core.windows.net"
+)
+
+// New returns the credential for an account: a token credential if OAuth or
+// a managed identity is configured, a shared key credential if there's an
+// account key, or an anonymous one for SAS tokens, which are sent in the URL.
+func New(c config.Credentials) (azblob.Credential, error) {
+	switch {
+	case c.OAuth != nil && c.ManagedIdentity != nil:
+		return nil, errors.New("only one of OAuth and a managed identity can be configured")
+	case c.OAuth != nil:
+		return NewClientCredential(*c.OAuth)
+	case c.ManagedIdentity != nil:
+		return NewManagedIdentityCredential(*c.ManagedIdentity)
+	case c.AzureAccountKey != "":
+		credential, err := azblob.NewSharedKeyCredential(c.AzureAccountName, c.AzureAccountKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create shared key credential")
+		}
+		return credential, nil
+	case c.SASToken != "":
+		return azblob.NewAnonymousCredential(), nil
+	}
+	return nil, errors.New("account key is required")
+}
+
+// usesSAS reports whether requests are authorized with the SAS token, which
+// is sent in the URL.
+func usesSAS(c config.Credentials) bool {
+	return c.OAuth == nil && c.ManagedIdentity == nil && c.AzureAccountKey == "" && c.SASToken != ""
+}
+
+// baseEndpoint returns the URL of an account's blob service.
+func baseEndpoint(c config.Credentials) (*url.URL, error) {
+	endpoint := c.BlobEndpoint
+	if endpoint == "" {
+		if c.AzureAccountName == "" {
+			return nil, errors.New("account name is required")
+		}
+		endpoint = fmt.Sprintf(serviceFmt, c.AzureAccountName)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid blob endpoint")
+	}
+	return u, nil
+}
+
+// NewServiceURL returns the URL of an account's blob service, with a pipeline
+// authorizing requests with its credentials.
+func NewServiceURL(c config.Credentials, o azblob.PipelineOptions) (azblob.ServiceURL, error) {
+	r, err := NewReloadable(c)
+	if err != nil {
+		return azblob.ServiceURL{}, err
+	}
+	return r.ServiceURL(o), nil
+}
@@ -0,0 +1,123 @@
+package credential
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/ehotinger/lightningfs/config"
+)
+
+func TestBaseEndpoint(t *testing.T) {
+	for _, test := range []struct {
+		c           config.Credentials
+		expected    string
+		shouldError bool
+	}{
+		{config.Credentials{AzureAccountName: "a", AzureAccountKey: "b"}, "https://a.blob.core.windows.net", false},
+		{config.Credentials{BlobEndpoint: "http://127.0.0.1:10000/devstoreaccount1", SASToken: "sv=1"}, "http://127.0.0.1:10000/devstoreaccount1", false},
+		{config.Credentials{AzureAccountKey: "b"}, "", true},
+	} {
+		actual, err := baseEndpoint(test.c)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatal("expected test to error, but it didn't")
+		}
+		if actual.String() != test.expected {
+			t.Fatalf("expected %s but got %s", test.expected, actual)
+		}
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	r, err := NewReloadable(config.Credentials{AzureAccountName: "a", SASToken: "?sv=1&sig=old"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// send returns the query a request is sent with.
+	send := func() url.Values {
+		var sent url.Values
+		next := pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			sent = request.URL.Query()
+			return nil, nil
+		})
+		u, _ := url.Parse("https://a.blob.core.windows.net/c?comp=list")
+		request, err := pipeline.NewRequest("GET", *u, nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		r.New(next, nil).Do(context.Background(), request)
+		return sent
+	}
+
+	if q := send(); q.Get("sig") != "old" || q.Get("comp") != "list" {
+		t.Fatalf("unexpected query: %v", q)
+	}
+	if err := r.Reload(config.Credentials{AzureAccountName: "a", SASToken: "sv=1&sig=new"}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if q := send(); q.Get("sig") != "new" || q.Get("comp") != "list" {
+		t.Fatalf("unexpected query: %v", q)
+	}
+
+	// Prepared credentials are only used once they're swapped in.
+	swap, err := r.Prepare(config.Credentials{AzureAccountName: "a", SASToken: "sv=1&sig=next"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if q := send(); q.Get("sig") != "new" {
+		t.Fatalf("expected the prepared credential to be unused but got %v", q)
+	}
+	swap()
+	if q := send(); q.Get("sig") != "next" {
+		t.Fatalf("unexpected query: %v", q)
+	}
+
+	if err := r.Reload(config.Credentials{AzureAccountName: "b", SASToken: "sv=1"}); err == nil {
+		t.Fatal("expected changing the account to error, but it didn't")
+	}
+	if q := send(); q.Get("sig") != "next" {
+		t.Fatalf("expected the credential to be kept but got %v", q)
+	}
+}
+
+func TestReloadStopsRefreshing(t *testing.T) {
+	var fetched int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Write([]byte(`{"token_type":"Bearer","expires_in":3599,"access_token":"token"}`))
+	}))
+	defer server.Close()
+
+	oauth := &config.OAuth{TenantID: "tenant", ClientID: "id", ClientSecret: "secret", AuthorityURL: server.URL}
+	r, err := NewReloadable(config.Credentials{AzureAccountName: "a", OAuth: oauth})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	old, ok := r.current.Load().(*authorizer).credential.(*refreshingToken)
+	if !ok {
+		t.Fatalf("expected a refreshing token credential")
+	}
+	if _, ok := r.Credential.(*refreshingToken); ok {
+		t.Fatalf("expected the token credential not to be embedded")
+	}
+
+	// The token is refreshed until the credential is replaced.
+	if d := old.refresh(old); d <= 0 || atomic.LoadInt32(&fetched) != 2 {
+		t.Fatalf("expected a refresh, but got %v after %d fetches", d, atomic.LoadInt32(&fetched))
+	}
+	if err := r.Reload(config.Credentials{AzureAccountName: "a", SASToken: "sv=1&sig=new"}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if d := old.refresh(old); d != 0 || atomic.LoadInt32(&fetched) != 2 {
+		t.Fatalf("expected refreshing to stop, but got %v after %d fetches", d, atomic.LoadInt32(&fetched))
+	}
+}
@@ -0,0 +1,46 @@
+package credential
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/defaults"
+)
+
+const (
+	imdsAPIVersion = "2018-02-01"
+)
+
+// NewManagedIdentityCredential returns a token credential for the managed
+// identity of the VM it runs on, acquiring tokens from the instance metadata
+// service.
+func NewManagedIdentityCredential(cfg config.ManagedIdentity) (azblob.TokenCredential, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaults.IMDSEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("api-version", imdsAPIVersion)
+	q.Set("resource", storageResource)
+	if cfg.ClientID != "" {
+		q.Set("client_id", cfg.ClientID)
+	}
+	u.RawQuery = q.Encode()
+
+	return newTokenCredential(func(ctx context.Context) (*token, error) {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		// Required, to guard against server side request forgery.
+		req.Header.Set("Metadata", "true")
+		return doTokenRequest(ctx, req)
+	})
+}
@@ -0,0 +1,48 @@
+package credential
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+)
+
+func TestNewManagedIdentityCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Header.Get("Metadata") != "true" || q.Get("resource") != storageResource || q.Get("api-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		if id := q.Get("client_id"); id != "" && id != "user" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request","error_description":"Identity not found"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"token","expires_in":"86399","token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		cfg         config.ManagedIdentity
+		shouldError bool
+	}{
+		{config.ManagedIdentity{Endpoint: server.URL}, false},
+		{config.ManagedIdentity{Endpoint: server.URL, ClientID: "user"}, false},
+		{config.ManagedIdentity{Endpoint: server.URL, ClientID: "other"}, true},
+	} {
+		credential, err := NewManagedIdentityCredential(test.cfg)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatal("expected test to error, but it didn't")
+		}
+		if token := credential.Token(); token != "token" {
+			t.Fatalf("expected token but got %q", token)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package credential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/defaults"
+	"github.com/pkg/errors"
+)
+
+// NewClientCredential returns a token credential for an Azure AD application,
+// acquiring tokens with the client credentials flow.
+func NewClientCredential(cfg config.OAuth) (azblob.TokenCredential, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("a tenant ID, client ID and client secret are required for OAuth")
+	}
+	authority := cfg.AuthorityURL
+	if authority == "" {
+		authority = defaults.AuthorityURL
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authority, "/"), url.PathEscape(cfg.TenantID))
+
+	return newTokenCredential(func(ctx context.Context) (*token, error) {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {cfg.ClientID},
+			"client_secret": {cfg.ClientSecret},
+			"scope":         {storageScope},
+		}
+		req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return doTokenRequest(ctx, req)
+	})
+}
+
+// doTokenRequest sends a request for a token and decodes the response.
+func doTokenRequest(ctx context.Context, req *http.Request) (*token, error) {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nil, fmt.Errorf("failed to request token: %s: %s %s", resp.Status, body.Error, body.Description)
+	}
+
+	t := &token{}
+	if err := json.NewDecoder(resp.Body).Decode(t); err != nil {
+		return nil, errors.Wrap(err, "failed to decode token")
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("failed to request token: the response has no access token")
+	}
+	return t, nil
+}
@@ -0,0 +1,82 @@
+package credential
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ehotinger/lightningfs/config"
+)
+
+func TestNewClientCredential(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "id" || r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Write([]byte(`{"token_type":"Bearer","expires_in":3599,"access_token":"token"}`))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		cfg         config.OAuth
+		shouldError bool
+	}{
+		{config.OAuth{TenantID: "tenant", ClientID: "id", ClientSecret: "secret", AuthorityURL: server.URL}, false},
+		{config.OAuth{TenantID: "tenant", ClientID: "id", ClientSecret: "wrong", AuthorityURL: server.URL}, true},
+		{config.OAuth{TenantID: "other", ClientID: "id", ClientSecret: "secret", AuthorityURL: server.URL}, true},
+		{config.OAuth{TenantID: "tenant", ClientID: "id", AuthorityURL: server.URL}, true},
+	} {
+		credential, err := NewClientCredential(test.cfg)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatal("expected test to error, but it didn't")
+		}
+		if token := credential.Token(); token != "token" {
+			t.Fatalf("expected token but got %q", token)
+		}
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	for _, test := range []struct {
+		data     string
+		expected time.Duration
+	}{
+		{`3599`, 3599 * time.Second},
+		{`"86399"`, 86399 * time.Second},
+	} {
+		var s seconds
+		if err := json.Unmarshal([]byte(test.data), &s); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if time.Duration(s) != test.expected {
+			t.Fatalf("expected %v but got %v", test.expected, time.Duration(s))
+		}
+	}
+}
+
+func TestRefreshDelay(t *testing.T) {
+	for _, test := range []struct {
+		expiresIn time.Duration
+		expected  time.Duration
+	}{
+		{time.Hour, 55 * time.Minute},
+		{8 * time.Minute, 4 * time.Minute},
+		{0, retryDelay},
+	} {
+		if actual := refreshDelay(test.expiresIn); actual != test.expected {
+			t.Fatalf("expected %v but got %v for %v", test.expected, actual, test.expiresIn)
+		}
+	}
+}
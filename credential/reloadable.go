@@ -0,0 +1,123 @@
+package credential
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/pkg/errors"
+)
+
+// Reloadable is a credential which can be replaced while pipelines are using
+// it, so that keys and tokens can be rotated without rebuilding them. SAS
+// tokens are added to each request rather than to the service URL for the
+// same reason.
+type Reloadable struct {
+	// Credential is anonymous, embedded only to satisfy azblob.Credential;
+	// requests are authorized by current.
+	azblob.Credential
+
+	endpoint *url.URL
+	current  atomic.Value // *authorizer
+}
+
+// authorizer is what requests are authorized with.
+type authorizer struct {
+	credential azblob.Credential
+	sas        url.Values
+}
+
+// stopper is implemented by credentials which refresh themselves in the
+// background.
+type stopper interface {
+	Stop()
+}
+
+// NewReloadable returns a reloadable credential for an account.
+func NewReloadable(c config.Credentials) (*Reloadable, error) {
+	endpoint, err := baseEndpoint(c)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newAuthorizer(c)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloadable{Credential: azblob.NewAnonymousCredential(), endpoint: endpoint}
+	r.current.Store(a)
+	return r, nil
+}
+
+func newAuthorizer(c config.Credentials) (*authorizer, error) {
+	credential, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	a := &authorizer{credential: credential}
+	if usesSAS(c) {
+		if a.sas, err = url.ParseQuery(strings.TrimPrefix(c.SASToken, "?")); err != nil {
+			return nil, errors.Wrap(err, "invalid SAS token")
+		}
+	}
+	return a, nil
+}
+
+// Reload replaces the credential. The account's endpoint can't change.
+func (r *Reloadable) Reload(c config.Credentials) error {
+	swap, err := r.Prepare(c)
+	if err != nil {
+		return err
+	}
+	swap()
+	return nil
+}
+
+// Prepare checks a replacement for the credential, returning a function which
+// swaps it in. This lets several credentials be checked before any of them is
+// replaced.
+func (r *Reloadable) Prepare(c config.Credentials) (func(), error) {
+	endpoint, err := baseEndpoint(c)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.String() != r.endpoint.String() {
+		return nil, errors.New("the account's endpoint can't change without remounting")
+	}
+	a, err := newAuthorizer(c)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		old := r.current.Load().(*authorizer)
+		r.current.Store(a)
+		// Requests already using the old credential keep its current token.
+		if s, ok := old.credential.(stopper); ok {
+			s.Stop()
+		}
+	}, nil
+}
+
+// ServiceURL returns the URL of the account's blob service, with a pipeline
+// authorizing requests with the current credential.
+func (r *Reloadable) ServiceURL(o azblob.PipelineOptions) azblob.ServiceURL {
+	return azblob.NewServiceURL(*r.endpoint, azblob.NewPipeline(r, o))
+}
+
+// New implements pipeline.Factory.
+func (r *Reloadable) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		a := r.current.Load().(*authorizer)
+		if a.sas != nil {
+			q := request.URL.Query()
+			for key, values := range a.sas {
+				q[key] = values
+			}
+			request.URL.RawQuery = q.Encode()
+		}
+		return a.credential.New(next, po).Do(ctx, request)
+	})
+}
@@ -0,0 +1,127 @@
+package credential
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	// storageResource and storageScope identify the blob service to token
+	// endpoints which take a resource and a scope respectively.
+	storageResource = "https://storage.azure.com/"
+	storageScope    = storageResource + ".default"
+
+	// refreshMargin is how long before a token expires it's refreshed.
+	refreshMargin = 5 * time.Minute
+
+	// retryDelay is how long to wait before trying again after failing to
+	// refresh a token.
+	retryDelay = 30 * time.Second
+
+	// fetchTimeout bounds each request for a token.
+	fetchTimeout = 30 * time.Second
+)
+
+// token is an OAuth access token.
+type token struct {
+	AccessToken string  `json:"access_token"`
+	ExpiresIn   seconds `json:"expires_in"`
+}
+
+// seconds is a duration in seconds. Some token endpoints encode it as a
+// string.
+type seconds time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *seconds) UnmarshalJSON(data []byte) error {
+	var raw json.Number
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		raw = json.Number(str)
+	}
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return err
+	}
+	*s = seconds(time.Duration(n) * time.Second)
+	return nil
+}
+
+// tokenSource requests a new token.
+type tokenSource func(ctx context.Context) (*token, error)
+
+// newTokenCredential fetches a token and returns a credential which keeps it
+// refreshed in the background until it's stopped.
+func newTokenCredential(fetch tokenSource) (azblob.TokenCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	initial, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &refreshingToken{fetch: fetch, initial: initial}
+	t.TokenCredential = azblob.NewTokenCredential(initial.AccessToken, t.refresh)
+	return t, nil
+}
+
+// refreshingToken is a token credential which refreshes its token until it's
+// stopped.
+type refreshingToken struct {
+	azblob.TokenCredential
+
+	fetch   tokenSource
+	initial *token
+	stopped int32
+}
+
+// Stop stops refreshing the token, e.g. once the credential's been replaced.
+func (t *refreshingToken) Stop() {
+	atomic.StoreInt32(&t.stopped, 1)
+}
+
+// refresh fetches a new token, returning how long to wait before doing so
+// again, or 0 once stopped. It's called straight away, with the token just
+// fetched.
+func (t *refreshingToken) refresh(credential azblob.TokenCredential) time.Duration {
+	if atomic.LoadInt32(&t.stopped) != 0 {
+		return 0
+	}
+	if t.initial != nil {
+		expiresIn := time.Duration(t.initial.ExpiresIn)
+		t.initial = nil
+		return refreshDelay(expiresIn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	next, err := t.fetch(ctx)
+	if err != nil {
+		log.Printf("failed to refresh token: %v", err)
+		return retryDelay
+	}
+	credential.SetToken(next.AccessToken)
+	return refreshDelay(time.Duration(next.ExpiresIn))
+}
+
+// refreshDelay returns how long to wait before refreshing a token which
+// expires in the given time.
+func refreshDelay(expiresIn time.Duration) time.Duration {
+	d := expiresIn - refreshMargin
+	if d < expiresIn/2 {
+		d = expiresIn / 2
+	}
+	if d < retryDelay {
+		d = retryDelay
+	}
+	return d
+}
@@ -0,0 +1,108 @@
+// Package dedup splits file contents into content-defined chunks which are
+// stored once, however many files contain them.
+package dedup
+
+import (
+	"io"
+)
+
+const (
+	// MinChunkSize, AvgChunkSize and MaxChunkSize bound the chunks data is
+	// split into. Chunks are small enough for the service to checksum when
+	// they're downloaded.
+	MinChunkSize = 256 << 10
+	AvgChunkSize = 1 << 20
+	MaxChunkSize = 4 << 20
+
+	// chunkMask has as many bits set as it takes for a boundary to occur
+	// every AvgChunkSize bytes on average.
+	chunkMask = AvgChunkSize - 1
+)
+
+// gear maps each byte to a random value for the rolling hash. It's generated
+// from a fixed seed so that boundaries never change.
+var gear = func() (table [256]uint64) {
+	// splitmix64
+	x := uint64(0x6c69676874696e67)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// Chunker splits a stream into content-defined chunks using a gear hash, so
+// that inserting or removing data only changes the chunks around it.
+type Chunker struct {
+	r   io.Reader
+	buf []byte
+
+	// start and end delimit the data read but not yet returned.
+	start, end int
+	eof        bool
+}
+
+// NewChunker returns a Chunker reading from r.
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, 2*MaxChunkSize)}
+}
+
+// Next returns the next chunk, or io.EOF once there are none left. The chunk
+// is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	n := cut(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// fill reads until at least MaxChunkSize bytes are buffered, or the stream
+// ends.
+func (c *Chunker) fill() error {
+	if c.end-c.start >= MaxChunkSize || c.eof {
+		return nil
+	}
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func cut(data []byte) int {
+	if len(data) <= MinChunkSize {
+		return len(data)
+	}
+	if len(data) > MaxChunkSize {
+		data = data[:MaxChunkSize]
+	}
+
+	var h uint64
+	for i := MinChunkSize; i < len(data); i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// chunks splits data into chunks, copying each.
+func chunks(t *testing.T, data []byte) [][]byte {
+	var chunks [][]byte
+	c := NewChunker(bytes.NewReader(data))
+	for {
+		p, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), p...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	random := make([]byte, 20<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, data := range [][]byte{
+		{},
+		[]byte("hello"),
+		random[:MinChunkSize+1],
+		random,
+		make([]byte, 10<<20),
+	} {
+		var joined []byte
+		chunks := chunks(t, data)
+		for i, chunk := range chunks {
+			if len(chunk) > MaxChunkSize || len(chunk) == 0 {
+				t.Fatalf("chunk %d has invalid size %d", i, len(chunk))
+			}
+			if len(chunk) < MinChunkSize && i != len(chunks)-1 {
+				t.Fatalf("chunk %d is smaller than the minimum: %d", i, len(chunk))
+			}
+			joined = append(joined, chunk...)
+		}
+		if !bytes.Equal(joined, data) {
+			t.Fatalf("expected %d bytes back but got %d", len(data), len(joined))
+		}
+	}
+}
+
+func TestChunkerShift(t *testing.T) {
+	data := make([]byte, 20<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	before := make(map[string]bool)
+	for _, chunk := range chunks(t, data) {
+		before[Hash(chunk)] = true
+	}
+
+	// Inserting data at the start should only change the chunks around it.
+	after := chunks(t, append([]byte("inserted"), data...))
+	var changed int
+	for _, chunk := range after {
+		if !before[Hash(chunk)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Fatalf("expected at most 2 of %d chunks to change but %d did", len(after), changed)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	m := &Manifest{Size: 12, Chunks: []Chunk{{Hash: Hash([]byte("hello ")), Size: 6}, {Hash: Hash([]byte("world!")), Size: 6}}}
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	actual, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if actual.Size != m.Size || len(actual.Chunks) != 2 || actual.Chunks[1] != m.Chunks[1] {
+		t.Fatalf("expected %+v but got %+v", m, actual)
+	}
+	if name := ChunkName("a/b", "abc"); name != "a/b/.chunks/abc" {
+		t.Fatalf("unexpected chunk name: %s", name)
+	}
+}
@@ -0,0 +1,148 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// GCOptions controls a garbage collection.
+type GCOptions struct {
+	// Prefix is the blob prefix mounted; chunks are stored under it.
+	Prefix string
+
+	// GracePeriod is how long a chunk has to go unreferenced before it's
+	// deleted. Chunks are uploaded before the manifests referencing them, so
+	// it must be longer than it takes to upload a file.
+	GracePeriod time.Duration
+
+	// DryRun reports what would be deleted without deleting anything.
+	DryRun bool
+
+	// IgnoreDeleted collects chunks even if there are soft deleted
+	// manifests. Those files won't be readable if they're restored.
+	IgnoreDeleted bool
+}
+
+// GCResult summarizes a garbage collection.
+type GCResult struct {
+	Manifests int
+	Chunks    int
+	Deleted   int
+	Freed     int64
+}
+
+// CollectGarbage deletes the chunks under a prefix which no manifest, or
+// snapshot of one, references.
+func CollectGarbage(ctx context.Context, containerURL azblob.ContainerURL, opts GCOptions) (GCResult, error) {
+	var result GCResult
+
+	prefix := strings.Trim(opts.Prefix, "/")
+	chunksPrefix := ChunkName(prefix, "") + "/"
+	listPrefix := ""
+	if prefix != "" {
+		listPrefix = prefix + "/"
+	}
+
+	type candidate struct {
+		name     string
+		modified time.Time
+		size     int64
+	}
+	var (
+		chunks     []candidate
+		referenced = make(map[string]bool)
+		deleted    int
+	)
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
+			Prefix:  listPrefix,
+			Details: azblob.BlobListingDetails{Metadata: true, Snapshots: true, Deleted: true},
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "failed to list %q", listPrefix)
+		}
+		marker = resp.NextMarker
+
+		for _, item := range resp.Segment.BlobItems {
+			if strings.HasPrefix(item.Name, chunksPrefix) {
+				if !item.Deleted && item.Snapshot == "" {
+					chunks = append(chunks, candidate{item.Name, item.Properties.LastModified, derefInt64(item.Properties.ContentLength)})
+				}
+				continue
+			}
+			if item.Metadata[ManifestMetadataKey] == "" {
+				continue
+			}
+			if item.Deleted {
+				// Deleted blobs can't be read, so their chunks are unknown.
+				deleted++
+				continue
+			}
+
+			manifest, err := readManifest(ctx, containerURL.NewBlobURL(item.Name).WithSnapshot(item.Snapshot))
+			if err != nil {
+				return result, errors.Wrapf(err, "failed to read manifest %s", item.Name)
+			}
+			result.Manifests++
+			for _, chunk := range manifest.Chunks {
+				referenced[ChunkName(prefix, chunk.Hash)] = true
+			}
+		}
+	}
+	if deleted > 0 && !opts.IgnoreDeleted {
+		return result, fmt.Errorf("%d soft deleted manifests may reference chunks; restore or purge them first", deleted)
+	}
+
+	result.Chunks = len(chunks)
+	for _, chunk := range chunks {
+		if referenced[chunk.name] || time.Since(chunk.modified) < opts.GracePeriod {
+			continue
+		}
+		if !opts.DryRun {
+			// Chunks touched since they were listed are being reused.
+			ac := azblob.BlobAccessConditions{
+				ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfUnmodifiedSince: chunk.modified},
+			}
+			if _, err := containerURL.NewBlobURL(chunk.name).Delete(ctx, azblob.DeleteSnapshotsOptionNone, ac); err != nil {
+				if serr, ok := storage.AsError(err); ok && serr.Response().StatusCode == http.StatusPreconditionFailed {
+					continue
+				}
+				return result, errors.Wrapf(err, "failed to delete %s", chunk.name)
+			}
+		}
+		result.Deleted++
+		result.Freed += chunk.size
+	}
+	return result, nil
+}
+
+// readManifest downloads and parses a manifest.
+func readManifest(ctx context.Context, blobURL azblob.BlobURL) (*Manifest, error) {
+	resp, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, err
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+func derefInt64(p *int64) int64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
@@ -0,0 +1,121 @@
+package dedup
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+)
+
+// newGCServer returns a fake Blob service holding chunks and manifests under
+// the prefix "data", along with the names of the chunks "a" to "e".
+//
+//   - a is referenced by a manifest.
+//   - b is referenced by a snapshot of a manifest.
+//   - c is unreferenced.
+//   - d is unreferenced, but newer than the grace period.
+//   - e is unreferenced, but is reused while it's being deleted.
+func newGCServer(t *testing.T) (*blobtest.Server, map[string]string) {
+	s := blobtest.NewServer()
+	old := time.Now().Add(-time.Hour)
+
+	names := make(map[string]string)
+	for _, data := range []string{"a", "b", "c", "d", "e"} {
+		names[data] = ChunkName("data", Hash([]byte(data)))
+		modified := old
+		if data == "d" {
+			modified = time.Now()
+		}
+		s.Put(blobtest.Blob{Name: names[data], Data: []byte(data), Modified: modified})
+	}
+
+	putManifest := func(b blobtest.Blob, chunk string) {
+		m := &Manifest{Size: 1, Chunks: []Chunk{{Hash: Hash([]byte(chunk)), Size: 1}}}
+		data, err := m.Marshal()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		b.Data = data
+		b.Metadata = map[string]string{ManifestMetadataKey: ManifestVersion}
+		s.Put(b)
+	}
+	putManifest(blobtest.Blob{Name: "data/a.bin"}, "a")
+	putManifest(blobtest.Blob{Name: "data/b.bin", Snapshot: "2019-01-01T00:00:00.0000000Z"}, "b")
+
+	// Deleting e finds it modified since it was listed.
+	s.Hook = func(r *http.Request) int {
+		if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, names["e"]) {
+			s.Touch(names["e"])
+		}
+		return 0
+	}
+	return s, names
+}
+
+func TestCollectGarbage(t *testing.T) {
+	for _, test := range []struct {
+		opts     GCOptions
+		expected GCResult
+		deleted  []string
+	}{
+		{GCOptions{Prefix: "data", GracePeriod: 10 * time.Minute}, GCResult{Manifests: 2, Chunks: 5, Deleted: 1, Freed: 1}, []string{"c"}},
+		{GCOptions{Prefix: "/data/", GracePeriod: 10 * time.Minute}, GCResult{Manifests: 2, Chunks: 5, Deleted: 1, Freed: 1}, []string{"c"}},
+		// Without a grace period, new chunks go too.
+		{GCOptions{Prefix: "data"}, GCResult{Manifests: 2, Chunks: 5, Deleted: 2, Freed: 2}, []string{"c", "d"}},
+		// A dry run can't tell that e is being reused.
+		{GCOptions{Prefix: "data", GracePeriod: 10 * time.Minute, DryRun: true}, GCResult{Manifests: 2, Chunks: 5, Deleted: 2, Freed: 2}, nil},
+	} {
+		s, names := newGCServer(t)
+		result, err := CollectGarbage(context.Background(), s.ContainerURL(), test.opts)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if result != test.expected {
+			t.Fatalf("expected %+v, but got %+v", test.expected, result)
+		}
+
+		var deleted []string
+		for _, data := range []string{"a", "b", "c", "d", "e"} {
+			if _, ok := s.Get(names[data]); !ok {
+				deleted = append(deleted, data)
+			}
+		}
+		if !reflect.DeepEqual(deleted, test.deleted) {
+			t.Fatalf("expected %v to be deleted, but got %v", test.deleted, deleted)
+		}
+		s.Close()
+	}
+}
+
+func TestCollectGarbageDeletedManifests(t *testing.T) {
+	s, names := newGCServer(t)
+	defer s.Close()
+	s.Put(blobtest.Blob{
+		Name:     "data/c.bin",
+		Deleted:  true,
+		Data:     []byte("{}"),
+		Metadata: map[string]string{ManifestMetadataKey: ManifestVersion},
+	})
+
+	// The deleted manifest might reference c.
+	opts := GCOptions{Prefix: "data", GracePeriod: 10 * time.Minute}
+	if _, err := CollectGarbage(context.Background(), s.ContainerURL(), opts); err == nil {
+		t.Fatal("expected soft deleted manifests to stop the collection, but they didn't")
+	}
+	if _, ok := s.Get(names["c"]); !ok {
+		t.Fatal("expected c to be kept")
+	}
+
+	opts.IgnoreDeleted = true
+	result, err := CollectGarbage(context.Background(), s.ContainerURL(), opts)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if result.Deleted != 1 {
+		t.Fatalf("expected 1 chunk to be deleted, but got %d", result.Deleted)
+	}
+}
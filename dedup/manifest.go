@@ -0,0 +1,56 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+)
+
+const (
+	// ChunksDir is the prefix chunks are stored under, relative to the root
+	// of the mount.
+	ChunksDir = ".chunks"
+
+	// ManifestMetadataKey marks blobs holding a manifest rather than data.
+	ManifestMetadataKey = "lightningmanifest"
+
+	// ManifestVersion is the version of the manifest format.
+	ManifestVersion = "1"
+)
+
+// Chunk is a chunk of a file.
+type Chunk struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Manifest lists the chunks a file is made of, in order.
+type Manifest struct {
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Hash returns the hash chunks are named after.
+func Hash(p []byte) string {
+	sum := sha256.Sum256(p)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChunkName returns the name of the blob holding a chunk, under the given
+// prefix.
+func ChunkName(prefix string, hash string) string {
+	return path.Join(prefix, ChunksDir, hash)
+}
+
+// ParseManifest unmarshals a manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	err := json.Unmarshal(data, m)
+	return m, err
+}
+
+// Marshal marshals a manifest.
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
@@ -0,0 +1,10 @@
+package defaults
+
+const (
+	// AuthorityURL is the default endpoint OAuth tokens are requested from.
+	AuthorityURL = "https://login.microsoftonline.com"
+
+	// IMDSEndpoint is the default endpoint managed identity tokens are
+	// requested from.
+	IMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
@@ -0,0 +1,9 @@
+package defaults
+
+import "time"
+
+const (
+	// GCGracePeriod is the default length of time a chunk has to go
+	// unreferenced before garbage collection deletes it.
+	GCGracePeriod = 24 * time.Hour
+)
@@ -1,6 +1,37 @@
 package defaults
 
+import "time"
+
 const (
 	// MntPoint is the default mount location.
 	MntPoint = "/mnt/lightning"
+
+	// MemoryBudget is the default number of bytes of file data held in memory
+	// before spilling to disk.
+	MemoryBudget = 256 << 20
+
+	// AttributeTTL is the default length of time the kernel may cache
+	// attributes for.
+	AttributeTTL = time.Minute
+
+	// EntryTTL is the default length of time the kernel may cache directory
+	// entries for.
+	EntryTTL = time.Minute
+
+	// ReadOnlyTTL is the default length of time the kernel may cache
+	// attributes and directory entries for on read-only mounts, where the
+	// data is assumed not to change.
+	ReadOnlyTTL = 24 * time.Hour
+
+	// ConfigCheckInterval is how often the config file is checked for
+	// changes to reload.
+	ConfigCheckInterval = 5 * time.Second
+
+	// ShutdownTimeout is how long unflushed files are given to upload when
+	// the mount is stopped.
+	ShutdownTimeout = 30 * time.Second
+
+	// ControlDir is where running mounts listen for lt status and lt unmount,
+	// if $XDG_RUNTIME_DIR isn't set.
+	ControlDir = "/run/lightningfs"
 )
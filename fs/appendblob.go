@@ -0,0 +1,99 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"syscall"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/errors"
+)
+
+// checkAppend returns an error unless writing p at off to an append blob file
+// only adds data to its end. The kernel may rewrite the tail of the file when
+// flushing its page cache, so writes which overlap the end are allowed as long
+// as the overlapping data is unchanged.
+func checkAppend(in *iNode, p []byte, off int64) error {
+	size := in.contents.Size()
+	if off > size {
+		log.Printf("rejecting write at %d to append blob %s: it would leave a hole after %d", off, in.name, size)
+		return syscall.EPERM
+	}
+
+	n := min64(int64(len(p)), size-off)
+	existing := make([]byte, n)
+	if _, err := in.contents.ReadAt(existing, off); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(existing, p[:n]) {
+		log.Printf("rejecting write at %d to append blob %s: only appends are allowed", off, in.name)
+		return syscall.EPERM
+	}
+	return nil
+}
+
+// appendBlocks appends [from, to) of an append blob file to its blob.
+func (fs *lightningFS) appendBlocks(ctx context.Context, in *iNode, from int64, to int64) error {
+	appendBlobURL := in.container.url.NewAppendBlobURL(in.name)
+	for from < to {
+		n := min64(to-from, azblob.AppendBlobMaxAppendBlockBytes)
+		buf := make([]byte, n)
+		if _, err := in.contents.ReadAt(buf, from); err != nil && err != io.EOF {
+			return errors.Wrapf(err, "failed to read %s", in.name)
+		}
+
+		// Guard against appending the same data twice.
+		bac := fs.accessConditions(in)
+		ac := azblob.AppendBlobAccessConditions{
+			ModifiedAccessConditions: bac.ModifiedAccessConditions,
+			LeaseAccessConditions:    bac.LeaseAccessConditions,
+		}
+		ac.IfAppendPositionEqual = from
+		if from == 0 {
+			ac.IfAppendPositionEqual = -1
+		}
+		resp, err := appendBlobURL.AppendBlock(ctx, bytes.NewReader(buf), ac, md5Sum(buf))
+		if err != nil {
+			return errors.Wrapf(err, "failed to append at %d to %s", from, in.name)
+		}
+		in.etag = resp.ETag()
+		from += n
+	}
+
+	in.remoteSize = to
+	in.dirty = false
+	return nil
+}
+
+// syncAppendBlob creates a file's append blob if it doesn't exist yet,
+// replacing any existing blob, and appends everything not yet uploaded.
+func (fs *lightningFS) syncAppendBlob(ctx context.Context, in *iNode) error {
+	if !in.exists {
+		appendBlobURL := in.container.url.NewAppendBlobURL(in.name)
+		resp, err := appendBlobURL.Create(ctx, azblob.BlobHTTPHeaders{}, azblob.Metadata{}, fs.accessConditions(in))
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s", in.name)
+		}
+		in.etag = resp.ETag()
+		in.exists = true
+		in.remoteSize = 0
+	}
+	return fs.appendBlocks(ctx, in, in.remoteSize, in.contents.Size())
+}
+
+// truncateAppendBlob handles a change in size of an append blob file. Append
+// blobs can only be emptied, which is done by replacing the blob.
+func (fs *lightningFS) truncateAppendBlob(in *iNode, size uint64) error {
+	switch {
+	case int64(size) == in.contents.Size():
+		return nil
+	case size != 0:
+		log.Printf("rejecting truncate of append blob %s to %d: append blobs can only be emptied", in.name, size)
+		return syscall.EPERM
+	}
+
+	in.exists = false
+	return nil
+}
@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestAppendBlobs(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, func(c *config.Config) {
+		c.AppendBlobPatterns = []string{"*.log"}
+	})
+	defer cleanup()
+
+	ctx := context.Background()
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "app.log", Mode: 0600}
+	if err := server.fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := create.Entry.Child
+
+	for _, test := range []struct {
+		name     string
+		offset   int64
+		data     string
+		size     *uint64
+		err      error
+		expected string
+	}{
+		{name: "append", data: "hello", expected: "hello"},
+		{name: "append at EOF", offset: 5, data: " world", expected: "hello world"},
+		// The kernel may rewrite the tail of the file unchanged.
+		{name: "rewrite tail", offset: 6, data: "world!", expected: "hello world!"},
+		{name: "overwrite", data: "HELLO", err: syscall.EPERM, expected: "hello world!"},
+		{name: "hole", offset: 100, data: "!", err: syscall.EPERM, expected: "hello world!"},
+		{name: "shrink", size: uint64Ptr(5), err: syscall.EPERM, expected: "hello world!"},
+		{name: "empty", size: uint64Ptr(0), expected: ""},
+		{name: "append after emptying", data: "again", expected: "again"},
+	} {
+		before, _ := blobs.Get("app.log")
+
+		var err error
+		if test.size != nil {
+			err = server.fs.SetInodeAttributes(ctx, &fuseops.SetInodeAttributesOp{Inode: id, Size: test.size})
+		} else {
+			err = server.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: id, Handle: create.Handle, Offset: test.offset, Data: []byte(test.data)})
+		}
+		if err != test.err {
+			t.Fatalf("%s: expected %v but got %v", test.name, test.err, err)
+		}
+
+		b, _ := blobs.Get("app.log")
+		if b.Type != azblob.BlobAppendBlob || string(b.Data) != test.expected {
+			t.Fatalf("%s: expected an append blob holding %q but got a %s holding %q", test.name, test.expected, b.Type, b.Data)
+		}
+		if test.err != nil && b.ETag != before.ETag {
+			t.Fatalf("%s: expected the blob to be left alone", test.name)
+		}
+	}
+}
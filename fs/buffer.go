@@ -0,0 +1,250 @@
+package fs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// stager accounts for the memory held by file buffers and hands out staging
+// files once the memory budget has been exhausted.
+type stager struct {
+	dir    string
+	budget int64
+
+	mu   sync.Mutex
+	used int64
+}
+
+func newStager(dir string, budget int64) *stager {
+	return &stager{
+		dir:    dir,
+		budget: budget,
+	}
+}
+
+// setBudget changes the memory budget, returning the old one. Data already in
+// memory stays there.
+func (s *stager) setBudget(budget int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.budget
+	s.budget = budget
+	return old
+}
+
+// reserve accounts n bytes against the memory budget. It returns false if
+// doing so would exceed the budget.
+func (s *stager) reserve(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.used+n > s.budget {
+		return false
+	}
+	s.used += n
+	return true
+}
+
+// release returns n bytes to the memory budget.
+func (s *stager) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used -= n
+}
+
+// tempFile creates a new staging file. The file is unlinked immediately so
+// that its space is reclaimed as soon as it's closed, even if we crash.
+func (s *stager) tempFile() (*os.File, error) {
+	f, err := ioutil.TempFile(s.dir, "lightning-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create staging file")
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to unlink staging file")
+	}
+	return f, nil
+}
+
+// fileBuffer holds the contents of a file as an extent map, so holes take up
+// no space. Data is kept in memory until the stager's budget is exhausted,
+// after which it's spilled to a sparse staging file so that file sizes are
+// bounded by disk rather than RAM.
+type fileBuffer struct {
+	s       *stager
+	extents extentMap
+	f       *os.File
+	size    int64
+}
+
+func newFileBuffer(s *stager) *fileBuffer {
+	return &fileBuffer{s: s}
+}
+
+// Size returns the logical size of the buffer.
+func (b *fileBuffer) Size() int64 {
+	return b.size
+}
+
+// Allocated returns the number of bytes of the buffer which hold data.
+// NB: the FUSE library derives st_blocks from the size, so the kernel doesn't
+// see this.
+func (b *fileBuffer) Allocated() int64 {
+	return b.extents.allocated()
+}
+
+// SeekData returns the first offset at or after off which holds data. ok is
+// false if the rest of the buffer is a hole. The FUSE library doesn't forward
+// lseek(2), so this is only used internally to skip holes.
+func (b *fileBuffer) SeekData(off int64) (pos int64, ok bool) {
+	if off >= b.size {
+		return 0, false
+	}
+	return b.extents.seekData(off)
+}
+
+// SeekHole returns the first offset at or after off which is in a hole. The
+// end of the buffer counts as a hole.
+func (b *fileBuffer) SeekHole(off int64) int64 {
+	return b.extents.seekHole(off, b.size)
+}
+
+func (b *fileBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	if max := b.size - off; int64(len(p)) > max {
+		p = p[:max]
+		err = io.EOF
+	}
+
+	// Holes read as zeros.
+	for i := range p {
+		p[i] = 0
+	}
+
+	end := off + int64(len(p))
+	for i := b.extents.search(off + 1); i < len(b.extents) && b.extents[i].off < end; i++ {
+		e := b.extents[i]
+		from, to := max64(e.off, off), min64(e.end, end)
+		if b.f == nil {
+			copy(p[from-off:to-off], e.data[from-e.off:])
+			continue
+		}
+		if _, ferr := b.f.ReadAt(p[from-off:to-off], from); ferr != nil && ferr != io.EOF {
+			return 0, ferr
+		}
+	}
+
+	n = len(p)
+	return
+}
+
+func (b *fileBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	if b.f == nil && !b.s.reserve(int64(len(p))) {
+		if err = b.spill(); err != nil {
+			return
+		}
+	}
+
+	if end := off + int64(len(p)); end > b.size {
+		if err = b.resize(end); err != nil {
+			return
+		}
+	}
+
+	if b.f == nil {
+		grown := b.extents.insert(p, off, true)
+		b.s.release(int64(len(p)) - grown)
+		return len(p), nil
+	}
+
+	if n, err = b.f.WriteAt(p, off); err != nil {
+		return
+	}
+	b.extents.insert(p, off, false)
+	return
+}
+
+// Truncate changes the size of the buffer. Extending the buffer leaves a hole.
+// Truncating it to zero closes its staging file, if any, so that emptied and
+// invalidated buffers don't keep a file descriptor open.
+func (b *fileBuffer) Truncate(size int64) error {
+	if size == 0 {
+		return b.Close()
+	}
+	if size < b.size {
+		b.s.release(b.extents.truncate(size))
+	}
+	return b.resize(size)
+}
+
+// resize sets the logical size of the buffer.
+func (b *fileBuffer) resize(size int64) error {
+	if b.f != nil {
+		// Extending the file leaves a hole rather than allocating zeros.
+		if err := b.f.Truncate(size); err != nil {
+			return errors.Wrap(err, "failed to resize staging file")
+		}
+	}
+	b.size = size
+	return nil
+}
+
+// spill moves the in-memory contents to a staging file.
+func (b *fileBuffer) spill() error {
+	f, err := b.s.tempFile()
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(b.size); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to resize staging file")
+	}
+	for _, e := range b.extents {
+		if _, err := f.WriteAt(e.data, e.off); err != nil {
+			f.Close()
+			return errors.Wrap(err, "failed to spill to staging file")
+		}
+	}
+
+	b.s.release(b.extents.allocated())
+	b.extents.dropData()
+	b.f = f
+	return nil
+}
+
+// Close releases the memory or staging file held by the buffer, leaving it
+// empty and back in memory.
+func (b *fileBuffer) Close() error {
+	if b.f == nil {
+		b.s.release(b.extents.allocated())
+	}
+	b.extents = nil
+	b.size = 0
+	if b.f == nil {
+		return nil
+	}
+	err := b.f.Close()
+	b.f = nil
+	return err
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFileBufferSpill(t *testing.T) {
+	for _, test := range []struct {
+		budget       int64
+		off          int64
+		data         []byte
+		expectedSize int64
+		shouldSpill  bool
+	}{
+		{1024, 0, []byte("hello"), 5, false},
+		{1024, 4096, []byte("hello"), 4101, false},
+		{4, 4096, []byte("hello"), 4101, true},
+		{0, 0, []byte("hello"), 5, true},
+	} {
+		s := newStager("", test.budget)
+		b := newFileBuffer(s)
+
+		n, err := b.WriteAt(test.data, test.off)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if n != len(test.data) {
+			t.Fatalf("expected to write %d bytes but wrote %d", len(test.data), n)
+		}
+		if b.Size() != test.expectedSize {
+			t.Fatalf("expected size %d but got %d", test.expectedSize, b.Size())
+		}
+		if spilled := b.f != nil; spilled != test.shouldSpill {
+			t.Fatalf("expected spilled to be %v but got %v", test.shouldSpill, spilled)
+		}
+
+		actual := make([]byte, b.Size()+1)
+		n, err = b.ReadAt(actual, 0)
+		if err != io.EOF {
+			t.Fatalf("expected EOF but got %v", err)
+		}
+		expected := make([]byte, test.expectedSize)
+		copy(expected[test.off:], test.data)
+		if !bytes.Equal(expected, actual[:n]) {
+			t.Fatalf("expected %q but got %q", expected, actual[:n])
+		}
+
+		if err := b.Close(); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if s.used != 0 {
+			t.Fatalf("expected budget to be released but %d bytes are in use", s.used)
+		}
+	}
+}
+
+func TestFileBufferTruncateToZero(t *testing.T) {
+	b := newFileBuffer(newStager("", 0))
+	if _, err := b.WriteAt([]byte("hello"), 0); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	f := b.f
+	if f == nil {
+		t.Fatal("expected the buffer to spill")
+	}
+
+	if err := b.Truncate(0); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if b.f != nil || b.Size() != 0 {
+		t.Fatalf("expected an empty buffer in memory, but got size %d, spilled %v", b.Size(), b.f != nil)
+	}
+	if err := f.Close(); err == nil {
+		t.Fatal("expected the staging file to be closed, but it wasn't")
+	}
+
+	// The buffer can still be written to.
+	b.s.setBudget(1024)
+	if _, err := b.WriteAt([]byte("again"), 0); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	actual := make([]byte, 5)
+	if _, err := b.ReadAt(actual, 0); err != nil || string(actual) != "again" || b.f != nil {
+		t.Fatalf("expected %q in memory, but got %q, %v", "again", actual, err)
+	}
+}
@@ -0,0 +1,46 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"log"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+// md5Sum returns the MD5 of p, as sent in Content-MD5 headers.
+func md5Sum(p []byte) []byte {
+	sum := md5.Sum(p)
+	return sum[:]
+}
+
+// verifyMD5 checks data read from a blob against the MD5 the service holds
+// for it, if any, returning EIO if they differ.
+func verifyMD5(name string, off int64, expected []byte, actual []byte) error {
+	if len(expected) == 0 || bytes.Equal(expected, actual) {
+		return nil
+	}
+	log.Printf("checksum mismatch reading %s at %d: expected %x but got %x", name, off, expected, actual)
+	return fuse.EIO
+}
+
+// downloadRange reads len(buf) bytes of a blob at off into buf, verifying them
+// against the MD5 the service computes for the range. The service only does so
+// for ranges of up to 4MiB.
+func downloadRange(ctx context.Context, blobURL azblob.BlobURL, name string, off int64, buf []byte, ac azblob.BlobAccessConditions) error {
+	resp, err := blobURL.Download(ctx, off, int64(len(buf)), ac, len(buf) <= blockSize)
+	if err != nil {
+		return errors.Wrapf(err, "failed to download %s", name)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return errors.Wrapf(err, "failed to download %s", name)
+	}
+	return verifyMD5(name, off, resp.ContentMD5(), md5Sum(buf))
+}
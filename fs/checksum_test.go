@@ -0,0 +1,58 @@
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestChecksumMismatch(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		contentMD5 []byte
+		corrupt    bool
+		err        error
+	}{
+		{"matching", md5Sum([]byte("hello")), false, nil},
+		{"no blob MD5", nil, false, nil},
+		{"blob MD5 mismatch", md5Sum([]byte("other")), false, fuse.EIO},
+		{"corrupted range", md5Sum([]byte("hello")), true, fuse.EIO},
+		{"corrupted range without a blob MD5", nil, true, fuse.EIO},
+	} {
+		blobs := blobtest.NewServer()
+		blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("hello"), ContentMD5: test.contentMD5})
+		blobs.CorruptRanges = test.corrupt
+
+		data, err := readFile(t, blobs, nil, "a.txt")
+		blobs.Close()
+		if err != test.err {
+			t.Fatalf("%s: expected %v, but got %v", test.name, test.err, err)
+		}
+		if err == nil && string(data) != "hello" {
+			t.Fatalf("%s: expected %q but got %q", test.name, "hello", data)
+		}
+	}
+}
+
+func TestCompressedChecksumMismatch(t *testing.T) {
+	compress := func(c *config.Config) {
+		c.CompressPatterns = []string{"*.csv"}
+	}
+	server, blobs, cleanup := newTestFS(t, compress)
+	defer cleanup()
+
+	id := createDirty(t, server, "a.csv")
+	if err := server.fs.SyncFile(context.Background(), &fuseops.SyncFileOp{Inode: id}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// Blocks read without loading the whole file are checked too.
+	blobs.CorruptRanges = true
+	if _, err := readFile(t, blobs, compress, "a.csv"); err != fuse.EIO {
+		t.Fatalf("expected EIO, but got %v", err)
+	}
+}
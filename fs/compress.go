@@ -0,0 +1,173 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+const (
+	// compressionMetadataKey records how a blob is compressed. Each block of a
+	// compressed blob is compressed separately, so that it can be read on its
+	// own.
+	compressionMetadataKey = "lightningcompression"
+
+	// chunksMetadataKey records the compressed length of each block, so that
+	// the blocks can be found without reading the blob.
+	chunksMetadataKey = "lightningchunks"
+
+	compressionGzip = "gzip"
+
+	// maxCompressedBlocks bounds the size of the chunk index, since all of a
+	// blob's metadata has to fit in 8KiB. Larger files aren't compressed.
+	maxCompressedBlocks = 1024
+)
+
+// shouldCompress reports whether a file should be compressed when it's next
+// uploaded.
+func (fs *lightningFS) shouldCompress(in *iNode) bool {
+	if in.blobType != azblob.BlobBlockBlob || in.contents.Size() > maxCompressedBlocks*blockSize {
+		return false
+	}
+	return matchAny(fs.compressPatterns, in.container.relativeName(in.name))
+}
+
+// compressChunk compresses a block.
+func compressChunk(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressChunk decompresses a block, appending it to dst.
+func decompressChunk(dst []byte, p []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf := bytes.NewBuffer(dst)
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressionMetadata returns the metadata recording how a file of the given
+// size was compressed into blocks of the given lengths.
+func compressionMetadata(size int64, lengths []int64) azblob.Metadata {
+	var index []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, length := range lengths {
+		n := binary.PutUvarint(buf, uint64(length))
+		index = append(index, buf[:n]...)
+	}
+	return azblob.Metadata{
+		compressionMetadataKey: compressionGzip,
+		chunksMetadataKey:      base64.StdEncoding.EncodeToString(index),
+		sizeMetadataKey:        strconv.FormatInt(size, 10),
+	}
+}
+
+// parseChunks returns the compressed length of each block of a blob, or nil
+// if it isn't compressed.
+func parseChunks(metadata azblob.Metadata) ([]int64, error) {
+	switch metadata[compressionMetadataKey] {
+	case "":
+		return nil, nil
+	case compressionGzip:
+	default:
+		return nil, fmt.Errorf("unknown compression: %q", metadata[compressionMetadataKey])
+	}
+
+	index, err := base64.StdEncoding.DecodeString(metadata[chunksMetadataKey])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed chunk index")
+	}
+	lengths := []int64{}
+	for len(index) > 0 {
+		length, n := binary.Uvarint(index)
+		if n <= 0 {
+			return nil, errors.New("malformed chunk index")
+		}
+		lengths = append(lengths, int64(length))
+		index = index[n:]
+	}
+	return lengths, nil
+}
+
+// readChunk downloads and decompresses the nth block of a compressed file. The
+// last block read is kept, since reads tend to be sequential.
+func (fs *lightningFS) readChunk(ctx context.Context, in *iNode, n int) ([]byte, error) {
+	if in.chunk != nil && in.chunkIndex == n {
+		return in.chunk, nil
+	}
+	if n >= len(in.chunks) {
+		log.Printf("failed to decompress %s: it's longer than its chunk index", in.name)
+		return nil, fuse.EIO
+	}
+
+	var off int64
+	for _, length := range in.chunks[:n] {
+		off += length
+	}
+	buf := make([]byte, in.chunks[n])
+	blobURL := in.container.url.NewBlobURL(in.name).WithSnapshot(in.snapshot)
+	ac := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: in.etag},
+	}
+	if err := downloadRange(ctx, blobURL, in.name, off, buf, ac); err != nil {
+		return nil, err
+	}
+
+	chunk, err := decompressChunk(make([]byte, 0, blockSize), buf)
+	if err != nil {
+		log.Printf("failed to decompress %s: %v", in.name, err)
+		return nil, fuse.EIO
+	}
+	in.chunk, in.chunkIndex = chunk, n
+	return chunk, nil
+}
+
+// readCompressed reads from a compressed file which hasn't been loaded,
+// decompressing only the blocks needed.
+func (fs *lightningFS) readCompressed(ctx context.Context, in *iNode, dst []byte, off int64) (int, error) {
+	if err := checkLoadable(in); err != nil {
+		return 0, err
+	}
+
+	size := int64(in.attrs.Size)
+	var n int
+	for n < len(dst) && off < size {
+		chunk, err := fs.readChunk(ctx, in, int(off/blockSize))
+		if err != nil {
+			return n, err
+		}
+		pos := off % blockSize
+		if pos >= int64(len(chunk)) {
+			log.Printf("failed to decompress %s: it's shorter than its recorded size", in.name)
+			return n, fuse.EIO
+		}
+		copied := copy(dst[n:], chunk[pos:min64(int64(len(chunk)), size-off+pos)])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
@@ -0,0 +1,52 @@
+package fs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+func TestCompressChunk(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		[]byte("hello"),
+		bytes.Repeat([]byte("a,b,c\n"), 100000),
+	} {
+		compressed, err := compressChunk(data)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		actual, err := decompressChunk(nil, compressed)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if !bytes.Equal(actual, data) {
+			t.Fatalf("expected %d bytes back but got %d", len(data), len(actual))
+		}
+	}
+}
+
+func TestParseChunks(t *testing.T) {
+	for _, test := range []struct {
+		metadata azblob.Metadata
+		expected []int64
+		err      bool
+	}{
+		{azblob.Metadata{}, nil, false},
+		{compressionMetadata(0, nil), []int64{}, false},
+		{compressionMetadata(10, []int64{23}), []int64{23}, false},
+		{compressionMetadata(5<<20, []int64{4096, 1 << 30}), []int64{4096, 1 << 30}, false},
+		{azblob.Metadata{compressionMetadataKey: "zstd"}, nil, true},
+		{azblob.Metadata{compressionMetadataKey: compressionGzip, chunksMetadataKey: "!"}, nil, true},
+	} {
+		actual, err := parseChunks(test.metadata)
+		if (err != nil) != test.err {
+			t.Fatalf("expected error %v but got %v for %v", test.err, err, test.metadata)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("expected %v but got %v for %v", test.expected, actual, test.metadata)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/storage"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+// parseConflictPolicy validates a conflict policy, defaulting to failing.
+func parseConflictPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return config.ConflictFail, nil
+	case config.ConflictFail, config.ConflictRename, config.ConflictOverwrite:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy: %q", policy)
+}
+
+// accessConditions returns the conditions an upload of a file must meet so
+// that it doesn't clobber changes made by someone else: the blob must be
+// unchanged since it was loaded, or must not exist yet if it's new.
+func (fs *lightningFS) accessConditions(in *iNode) azblob.BlobAccessConditions {
+	ac := azblob.BlobAccessConditions{LeaseAccessConditions: in.leaseAccessConditions()}
+	switch {
+	case fs.conflictPolicy == config.ConflictOverwrite:
+	case in.etag == azblob.ETagNone:
+		ac.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	default:
+		ac.ModifiedAccessConditions.IfMatch = in.etag
+	}
+	return ac
+}
+
+// isConflict reports whether err was caused by a blob having been changed by
+// someone else.
+func isConflict(err error) bool {
+	serr, ok := storage.AsError(err)
+	if !ok {
+		return false
+	}
+	switch serr.ServiceCode() {
+	case azblob.ServiceCodeConditionNotMet, azblob.ServiceCodeBlobAlreadyExists:
+		return true
+	}
+	return serr.Response().StatusCode == http.StatusPreconditionFailed
+}
+
+// resolveConflict handles a failed upload of a file whose blob was changed by
+// someone else, according to the conflict policy.
+func (fs *lightningFS) resolveConflict(ctx context.Context, in *iNode, err error) error {
+	if fs.conflictPolicy != config.ConflictRename {
+		log.Printf("failed to upload %s: it was changed by someone else: %v", in.name, err)
+		return fuse.EIO
+	}
+
+	parent, perr := fs.getINode(in.parent)
+	if perr != nil {
+		return perr
+	}
+
+	// Any lease we hold is on their blob, not ours.
+	if err := fs.releaseLease(ctx, in); err != nil {
+		return err
+	}
+
+	// Keep our version under a new name, alongside theirs.
+	host, _ := os.Hostname()
+	oldName := path.Base(in.name)
+	newName := fmt.Sprintf("%s.conflict-%s-%s", oldName, host, time.Now().UTC().Format("20060102T150405Z"))
+	if !parent.renameChild(oldName, newName) {
+		return fuse.EIO
+	}
+
+	theirs := in.name
+	in.name = path.Join(parent.name, newName)
+	in.etag = azblob.ETagNone
+	in.exists = false
+	in.remoteSize = 0
+	log.Printf("%s was changed by someone else, uploading our version as %s", theirs, in.name)
+
+	if err := fs.syncBlob(ctx, in); err != nil {
+		return err
+	}
+
+	// Make their version visible again.
+	props, err := in.container.url.NewBlobURL(theirs).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get properties of %s", theirs)
+	}
+	fs.addBlob(in.parent, parent, oldName, blobItemFromProperties(theirs, props))
+	return nil
+}
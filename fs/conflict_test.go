@@ -0,0 +1,65 @@
+package fs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestResolveConflict(t *testing.T) {
+	for _, test := range []struct {
+		policy   string
+		err      error
+		expected string
+		renamed  bool
+	}{
+		{config.ConflictFail, fuse.EIO, "theirs", false},
+		{config.ConflictRename, nil, "theirs", true},
+		{config.ConflictOverwrite, nil, "mine", false},
+	} {
+		server, blobs, cleanup := newTestFS(t, func(c *config.Config) { c.ConflictPolicy = test.policy })
+
+		ctx := context.Background()
+		create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "a.txt", Mode: 0600}
+		if err := server.fs.CreateFile(ctx, create); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		id := create.Entry.Child
+		if err := server.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: id, Data: []byte("ours")}); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id}); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		// Someone else changes the blob before our next upload.
+		blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("theirs")})
+		if err := server.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: id, Data: []byte("mine")}); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id})
+		if err != test.err {
+			t.Fatalf("%s: expected %v, but got %v", test.policy, test.err, err)
+		}
+
+		if b, _ := blobs.Get("a.txt"); string(b.Data) != test.expected {
+			t.Fatalf("%s: expected a.txt to be %q, but got %q", test.policy, test.expected, b.Data)
+		}
+		renamed := false
+		for _, name := range blobs.Names() {
+			if strings.HasPrefix(name, "a.txt.conflict-") {
+				b, _ := blobs.Get(name)
+				renamed = string(b.Data) == "mine"
+			}
+		}
+		if renamed != test.renamed {
+			t.Fatalf("%s: expected our version to be kept under a new name: %v, but got %v", test.policy, test.renamed, renamed)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,165 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/credential"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/pkg/errors"
+)
+
+// container is a container, or a prefix of one, backing part of the tree.
+type container struct {
+	url azblob.ContainerURL
+
+	// prefix is the blob prefix mounted, without a trailing delimiter.
+	prefix string
+}
+
+// relativeName returns the name of a blob relative to the mounted prefix.
+func (c *container) relativeName(name string) string {
+	if c.prefix == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, c.prefix+blobDelimiter)
+}
+
+// mountRoot sets up what the root directory shows: a single container, every
+// container in an account, or a directory for each of the configured mounts.
+func (fs *lightningFS) mountRoot(cfg *config.Config) error {
+	root := fs.inodes[fuseops.RootInodeID]
+	if len(cfg.Mounts) == 0 {
+		return fs.mount(fuseops.RootInodeID, root, config.Mount{
+			Credentials:   cfg.Credentials,
+			ContainerName: cfg.ContainerName,
+			Prefix:        cfg.Prefix,
+		})
+	}
+
+	// The mounts never change, so the root doesn't need listing.
+	root.listed = true
+	for _, m := range cfg.Mounts {
+		name := m.Name
+		switch {
+		case name == "" && m.ContainerName != "":
+			name = m.ContainerName
+		case name == "":
+			name = m.AzureAccountName
+		}
+		if name == "" || strings.Contains(name, blobDelimiter) {
+			return fmt.Errorf("invalid mount name: %q", name)
+		}
+		if _, _, ok := root.LookUpChild(name); ok {
+			return fmt.Errorf("duplicate mount name: %q", name)
+		}
+
+		id, dir := fs.addDir(fuseops.RootInodeID, root, name)
+		if err := fs.mount(id, dir, m); err != nil {
+			return errors.Wrapf(err, "failed to mount %s", name)
+		}
+	}
+	return nil
+}
+
+// mount backs a directory with a container, or with an account whose
+// containers are listed as subdirectories if no container is given.
+func (fs *lightningFS) mount(id fuseops.InodeID, dir *iNode, m config.Mount) error {
+	cred, err := credential.NewReloadable(m.Credentials)
+	if err != nil {
+		return err
+	}
+	fs.credentials = append(fs.credentials, cred)
+	serviceURL := cred.ServiceURL(azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{}, // TODO: retries
+	})
+
+	if m.ContainerName == "" {
+		if m.Prefix != "" {
+			return errors.New("a prefix requires a container")
+		}
+		dir.account = &serviceURL
+		return nil
+	}
+
+	prefix, err := parsePrefix(m.Prefix)
+	if err != nil {
+		return err
+	}
+	fs.attachContainer(id, dir, &container{
+		url:    serviceURL.NewContainerURL(m.ContainerName),
+		prefix: prefix,
+	})
+	return nil
+}
+
+// attachContainer backs a directory with a container.
+func (fs *lightningFS) attachContainer(id fuseops.InodeID, dir *iNode, c *container) {
+	// Names are blob names, so everything is created under the prefix.
+	dir.name = c.prefix
+	dir.container = c
+	fs.containerRoots = append(fs.containerRoots, id)
+}
+
+// detachContainer stops tracking a container which has been deleted.
+func (fs *lightningFS) detachContainer(id fuseops.InodeID) {
+	for i, root := range fs.containerRoots {
+		if root == id {
+			fs.containerRoots = append(fs.containerRoots[:i], fs.containerRoots[i+1:]...)
+			return
+		}
+	}
+}
+
+// listContainers populates an account's directory with its containers, and
+// refreshes it once its entries have expired.
+func (fs *lightningFS) listContainers(ctx context.Context, id fuseops.InodeID, dir *iNode) error {
+	if dir.listed && time.Since(dir.validated) < fs.entryTTL {
+		return nil
+	}
+
+	account := dir.account
+	var items []azblob.ContainerItem
+	err := fs.unlocked(func() error {
+		for marker := (azblob.Marker{}); marker.NotDone(); {
+			resp, err := account.ListContainersSegment(ctx, marker, azblob.ListContainersSegmentOptions{})
+			if err != nil {
+				return errors.Wrap(err, "failed to list containers")
+			}
+			marker = resp.NextMarker
+			items = append(items, resp.ContainerItems...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		seen[item.Name] = true
+		if _, _, ok := dir.LookUpChild(item.Name); ok {
+			continue
+		}
+		childID, child := fs.addDir(id, dir, item.Name)
+		fs.attachContainer(childID, child, &container{url: account.NewContainerURL(item.Name)})
+	}
+
+	if dir.listed {
+		for _, e := range dir.entries {
+			if e.Type != fuseutil.DT_Unknown && !seen[e.Name] {
+				fs.detachContainer(e.Inode)
+				dir.removeChild(e.Name)
+			}
+		}
+	}
+
+	dir.listed = true
+	dir.validated = time.Now()
+	return nil
+}
@@ -0,0 +1,162 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/dedup"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+// isChunksDir reports whether name in dir is the directory chunks are stored
+// in, which is hidden and can't be written to.
+func (fs *lightningFS) isChunksDir(dir *iNode, name string) bool {
+	return fs.dedup && name == dedup.ChunksDir && dir.container != nil && dir.name == dir.container.prefix
+}
+
+// syncManifest uploads the contents of a file as content-defined chunks,
+// skipping any already stored, followed by a manifest listing them. They're
+// uploaded without holding the lock.
+func (fs *lightningFS) syncManifest(ctx context.Context, in *iNode) error {
+	var (
+		name   = in.name
+		c      = in.container
+		ac     = fs.accessConditions(in)
+		size   = in.contents.Size()
+		reader = io.NewSectionReader(in.contents, 0, size)
+		resp   *azblob.BlockBlobUploadResponse
+	)
+	err := fs.unlocked(func() error {
+		chunker := dedup.NewChunker(reader)
+		manifest := &dedup.Manifest{Size: size, Chunks: []dedup.Chunk{}}
+		stored := make(map[string]bool)
+		for {
+			p, err := chunker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to read %s", name)
+			}
+
+			hash := dedup.Hash(p)
+			if !stored[hash] {
+				if err := fs.putChunk(ctx, c, hash, p); err != nil {
+					return err
+				}
+				stored[hash] = true
+			}
+			manifest.Chunks = append(manifest.Chunks, dedup.Chunk{Hash: hash, Size: int64(len(p))})
+		}
+
+		data, err := manifest.Marshal()
+		if err != nil {
+			return err
+		}
+		metadata := azblob.Metadata{
+			dedup.ManifestMetadataKey: dedup.ManifestVersion,
+			sizeMetadataKey:           strconv.FormatInt(size, 10),
+		}
+		headers := azblob.BlobHTTPHeaders{ContentMD5: md5Sum(data)}
+		blobURL := c.url.NewBlockBlobURL(name)
+		if resp, err = blobURL.Upload(ctx, bytes.NewReader(data), headers, metadata, ac); err != nil {
+			return errors.Wrapf(err, "failed to upload manifest of %s", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Manifests are left in the default tier; archiving one would make its
+	// file unreadable while its chunks stay online.
+	in.etag = resp.ETag()
+	in.exists = true
+	in.dirty = false
+	in.chunks, in.chunk = nil, nil
+	return nil
+}
+
+// putChunk stores a chunk unless it's already stored. Existing chunks are
+// touched instead, so that garbage collection doesn't remove them before the
+// manifest referencing them is uploaded.
+func (fs *lightningFS) putChunk(ctx context.Context, c *container, hash string, p []byte) error {
+	name := dedup.ChunkName(c.prefix, hash)
+	blobURL := c.url.NewBlockBlobURL(name)
+
+	_, err := blobURL.SetMetadata(ctx, nil, azblob.BlobAccessConditions{})
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return errors.Wrapf(err, "failed to touch chunk %s", name)
+	}
+
+	headers := azblob.BlobHTTPHeaders{ContentMD5: md5Sum(p)}
+	ac := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny},
+	}
+	// Someone else storing the same chunk first is fine.
+	if _, err := blobURL.Upload(ctx, bytes.NewReader(p), headers, nil, ac); err != nil && !isConflict(err) {
+		return errors.Wrapf(err, "failed to upload chunk %s", name)
+	}
+	return nil
+}
+
+// downloadManifest reads a file stored as a manifest into contents, assembling
+// them from its chunks and checking each against its hash.
+func (fs *lightningFS) downloadManifest(ctx context.Context, in *iNode, contents *fileBuffer, blobURL azblob.BlobURL, props *azblob.BlobGetPropertiesResponse) error {
+	data := make([]byte, props.ContentLength())
+	ac := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()},
+	}
+	if err := downloadRange(ctx, blobURL, in.name, 0, data, ac); err != nil {
+		return err
+	}
+	if err := verifyMD5(in.name, 0, props.ContentMD5(), md5Sum(data)); err != nil {
+		return err
+	}
+	manifest, err := dedup.ParseManifest(data)
+	if err != nil {
+		log.Printf("failed to load %s: malformed manifest: %v", in.name, err)
+		return fuse.EIO
+	}
+
+	var off int64
+	for _, chunk := range manifest.Chunks {
+		if chunk.Size <= 0 || chunk.Size > dedup.MaxChunkSize {
+			log.Printf("failed to load %s: chunk %s has invalid size %d", in.name, chunk.Hash, chunk.Size)
+			return fuse.EIO
+		}
+		name := dedup.ChunkName(in.container.prefix, chunk.Hash)
+		p := make([]byte, chunk.Size)
+		if err := downloadRange(ctx, in.container.url.NewBlobURL(name), name, 0, p, azblob.BlobAccessConditions{}); err != nil {
+			return err
+		}
+		if hash := dedup.Hash(p); hash != chunk.Hash {
+			log.Printf("failed to load %s: chunk %s hashes to %s", in.name, chunk.Hash, hash)
+			return fuse.EIO
+		}
+
+		// Leave runs of zeros as holes.
+		if isZero(p) {
+			err = contents.Truncate(off + chunk.Size)
+		} else {
+			_, err = contents.WriteAt(p, off)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to buffer %s", in.name)
+		}
+		off += chunk.Size
+	}
+	if off != manifest.Size {
+		log.Printf("failed to load %s: expected %d bytes but got %d", in.name, manifest.Size, off)
+		return fuse.EIO
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/dedup"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	enableDedup := func(c *config.Config) { c.Dedup = true }
+	server, blobs, cleanup := newTestFS(t, enableDedup)
+	defer cleanup()
+
+	// Repeating the data makes chunks repeat.
+	block := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(block)
+	data := bytes.Repeat(block, 4)
+
+	ctx := context.Background()
+	for _, name := range []string{"a.bin", "b.bin"} {
+		create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: name, Mode: 0600}
+		if err := server.fs.CreateFile(ctx, create); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if err := server.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: create.Entry.Child, Data: data}); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: create.Entry.Child}); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	var chunks []string
+	for _, name := range blobs.Names() {
+		if strings.HasPrefix(name, dedup.ChunksDir+"/") {
+			chunks = append(chunks, name)
+		}
+	}
+	b, ok := blobs.Get("a.bin")
+	if !ok || b.Metadata[dedup.ManifestMetadataKey] != dedup.ManifestVersion {
+		t.Fatal("expected a.bin to be stored as a manifest")
+	}
+	manifest, err := dedup.ParseManifest(b.Data)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	var size int64
+	unique := make(map[string]bool)
+	for _, chunk := range manifest.Chunks {
+		size += chunk.Size
+		unique[chunk.Hash] = true
+	}
+	// b.bin has the same contents, so it stores no chunks of its own.
+	if size != int64(len(data)) || len(unique) != len(chunks) || len(chunks) >= len(manifest.Chunks) {
+		t.Fatalf("expected %d bytes in %d chunks with repeats to be stored once, but got %d bytes in %d chunks and %d stored",
+			len(data), len(manifest.Chunks), size, len(manifest.Chunks), len(chunks))
+	}
+
+	// Another mount reads the files back from their chunks.
+	read := func(name string) ([]byte, error) {
+		other, _, cleanup := newTestFS(t, enableDedup)
+		defer cleanup()
+		other.fs.inodes[fuseops.RootInodeID].container.url = blobs.ContainerURL()
+
+		lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+		if err := other.fs.LookUpInode(ctx, lookUp); err != nil {
+			return nil, err
+		}
+		op := &fuseops.ReadFileOp{Inode: lookUp.Entry.Child, Dst: make([]byte, len(data)+1)}
+		err := other.fs.ReadFile(ctx, op)
+		return op.Dst[:op.BytesRead], err
+	}
+	actual, err := read("a.bin")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !bytes.Equal(actual, data) {
+		t.Fatalf("expected %d bytes to round trip, but got %d different ones", len(data), len(actual))
+	}
+
+	// Chunks are checked against their hashes.
+	chunk, _ := blobs.Get(chunks[0])
+	corrupt := append([]byte{}, chunk.Data...)
+	corrupt[0]++
+	blobs.Put(blobtest.Blob{Name: chunks[0], Data: corrupt})
+	if _, err := read("b.bin"); err != fuse.EIO {
+		t.Fatalf("expected EIO reading a corrupt chunk, but got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package fs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+const (
+	// encryptionChunkSize is the size of the chunks files are encrypted in.
+	// Each is sealed separately so that it can be read on its own.
+	encryptionChunkSize = 64 << 10
+
+	// encryptionOverhead is the size of the authentication tag added to each
+	// chunk.
+	encryptionOverhead = 16
+
+	// sealedChunkSize is the size of a whole chunk once it's encrypted.
+	sealedChunkSize = encryptionChunkSize + encryptionOverhead
+
+	// encryptedRangeSize is the most ciphertext downloaded at once: as many
+	// whole chunks as fit in a range the service will checksum.
+	encryptedRangeSize = blockSize / sealedChunkSize * sealedChunkSize
+
+	// keyMetadataKey holds the data key a blob is encrypted with, itself
+	// encrypted with the key from the keyfile.
+	keyMetadataKey = "lightningkey"
+
+	// keyIDMetadataKey identifies the key the data key is encrypted with.
+	keyIDMetadataKey = "lightningkeyid"
+)
+
+// encryptionKey is the key data keys are wrapped with.
+type encryptionKey struct {
+	aead cipher.AEAD
+
+	// id is a fingerprint of the key, so that blobs encrypted with another
+	// key can be told apart.
+	id string
+}
+
+// loadEncryptionKey reads a base64 encoded 256 bit key from a keyfile.
+func loadEncryptionKey(file string) (*encryptionKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read keyfile")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode keyfile")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("keyfile holds a %d bit key, expected 256 bits", len(key)*8)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &encryptionKey{aead: aead, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newDataKey generates a key to encrypt one version of a blob with. A new key
+// is used for every upload, so chunk numbers can safely be used as nonces.
+// metadata holds the wrapped key, to be stored with the blob.
+func (k *encryptionKey) newDataKey() (aead cipher.AEAD, metadata azblob.Metadata, err error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate data key")
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	aead, err = newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := k.aead.Seal(nonce, nonce, key, nil)
+	return aead, azblob.Metadata{
+		keyMetadataKey:   base64.StdEncoding.EncodeToString(wrapped),
+		keyIDMetadataKey: k.id,
+	}, nil
+}
+
+// dataKey unwraps the data key a blob is encrypted with.
+func (k *encryptionKey) dataKey(metadata azblob.Metadata) (cipher.AEAD, error) {
+	if id := metadata[keyIDMetadataKey]; id != k.id {
+		return nil, fmt.Errorf("encrypted with key %s, not %s", id, k.id)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[keyMetadataKey])
+	if err != nil || len(wrapped) < k.aead.NonceSize() {
+		return nil, errors.New("malformed data key")
+	}
+	n := k.aead.NonceSize()
+	key, err := k.aead.Open(nil, wrapped[:n], wrapped[n:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unwrap data key")
+	}
+	return newAEAD(key)
+}
+
+// chunkNonce returns the nonce of the nth chunk of a blob.
+func chunkNonce(n int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(n))
+	return nonce
+}
+
+// sealedSize returns the size of a blob holding size bytes once encrypted.
+func sealedSize(size int64) int64 {
+	chunks := (size + encryptionChunkSize - 1) / encryptionChunkSize
+	return size + chunks*encryptionOverhead
+}
+
+// sealChunks encrypts plaintext starting at chunk n, appending to dst.
+func sealChunks(aead cipher.AEAD, dst []byte, plaintext []byte, n int64) []byte {
+	for len(plaintext) > 0 {
+		chunk := plaintext[:min64(encryptionChunkSize, int64(len(plaintext)))]
+		dst = aead.Seal(dst, chunkNonce(n), chunk, nil)
+		plaintext = plaintext[len(chunk):]
+		n++
+	}
+	return dst
+}
+
+// openChunks decrypts ciphertext starting at chunk n, appending to dst.
+func openChunks(aead cipher.AEAD, dst []byte, ciphertext []byte, n int64) ([]byte, error) {
+	for len(ciphertext) > 0 {
+		chunk := ciphertext[:min64(sealedChunkSize, int64(len(ciphertext)))]
+		var err error
+		if dst, err = aead.Open(dst, chunkNonce(n), chunk, nil); err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt chunk %d", n)
+		}
+		ciphertext = ciphertext[len(chunk):]
+		n++
+	}
+	return dst, nil
+}
+
+// dataKey returns the data key a file's blob is encrypted with, or nil if it
+// isn't encrypted.
+func (fs *lightningFS) dataKey(in *iNode, metadata azblob.Metadata) (cipher.AEAD, error) {
+	if _, ok := metadata[keyMetadataKey]; !ok {
+		return nil, nil
+	}
+	if fs.encryptionKey == nil {
+		log.Printf("%s is encrypted but no keyfile is configured", in.name)
+		return nil, syscall.EACCES
+	}
+	aead, err := fs.encryptionKey.dataKey(metadata)
+	if err != nil {
+		log.Printf("failed to decrypt %s: %v", in.name, err)
+		return nil, fuse.EIO
+	}
+	return aead, nil
+}
+
+// readEncrypted reads from an encrypted file which hasn't been loaded,
+// downloading and decrypting only the chunks needed.
+func (fs *lightningFS) readEncrypted(ctx context.Context, in *iNode, dst []byte, off int64) (int, error) {
+	if err := checkLoadable(in); err != nil {
+		return 0, err
+	}
+	aead, err := fs.dataKey(in, in.keyMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	// Chunks dropped from or added to the end of the blob would otherwise go
+	// unnoticed.
+	size := int64(in.attrs.Size)
+	if in.remoteSize != sealedSize(size) {
+		log.Printf("failed to decrypt %s: expected %d bytes of ciphertext but it has %d", in.name, sealedSize(size), in.remoteSize)
+		return 0, fuse.EIO
+	}
+
+	blobURL := in.container.url.NewBlobURL(in.name).WithSnapshot(in.snapshot)
+	ac := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: in.etag},
+	}
+	end := min64(off+int64(len(dst)), size)
+	var (
+		n         int
+		sealed    []byte
+		plaintext []byte
+	)
+	// Download in ranges small enough for the service to checksum.
+	for first := off / encryptionChunkSize; off < end; first = off / encryptionChunkSize {
+		last := min64((end-1)/encryptionChunkSize, first+encryptedRangeSize/sealedChunkSize-1)
+		length := min64((last+1)*encryptionChunkSize, size) - first*encryptionChunkSize
+		sealedLength := length + (last+1-first)*encryptionOverhead
+		if int64(cap(sealed)) < sealedLength {
+			sealed = make([]byte, sealedLength)
+		}
+		sealed = sealed[:sealedLength]
+		if err := downloadRange(ctx, blobURL, in.name, first*sealedChunkSize, sealed, ac); err != nil {
+			return n, err
+		}
+		if plaintext, err = openChunks(aead, plaintext[:0], sealed, first); err != nil {
+			log.Printf("failed to decrypt %s: %v", in.name, err)
+			return n, fuse.EIO
+		}
+
+		pos := off - first*encryptionChunkSize
+		copied := copy(dst[n:], plaintext[pos:min64(int64(len(plaintext)), end-first*encryptionChunkSize)])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// checkEncryptable rejects writes to page and append blobs when encrypting;
+// they're written in place, which would reuse a data key's nonces.
+func (fs *lightningFS) checkEncryptable(in *iNode) error {
+	if fs.encryptionKey == nil || in.blobType == azblob.BlobBlockBlob {
+		return nil
+	}
+	log.Printf("rejecting write to %s: %s blobs can't be encrypted", in.name, in.blobType)
+	return syscall.EPERM
+}
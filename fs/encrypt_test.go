@@ -0,0 +1,178 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// newKeyFile writes a random key to a keyfile. The returned function removes
+// it.
+func newKeyFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "lightning-")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	file := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected err: %v", err)
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestEncryptChunks(t *testing.T) {
+	file, cleanup := newKeyFile(t)
+	defer cleanup()
+
+	k, err := loadEncryptionKey(file)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for _, test := range []struct {
+		size  int
+		first int64
+	}{
+		{0, 0},
+		{1, 0},
+		{encryptionChunkSize, 0},
+		{encryptionChunkSize + 1, 0},
+		{3*encryptionChunkSize - 5, 64},
+	} {
+		aead, metadata, err := k.newDataKey()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		plaintext := make([]byte, test.size)
+		rand.Read(plaintext)
+		ciphertext := sealChunks(aead, nil, plaintext, test.first)
+
+		aead, err = k.dataKey(metadata)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		actual, err := openChunks(aead, nil, ciphertext, test.first)
+		if err != nil {
+			t.Fatalf("unexpected err for size %d: %v", test.size, err)
+		}
+		if !bytes.Equal(actual, plaintext) {
+			t.Fatalf("expected the plaintext back for size %d", test.size)
+		}
+
+		// Chunks can't be moved around.
+		if test.size > 0 {
+			if _, err := openChunks(aead, nil, ciphertext, test.first+1); err == nil {
+				t.Fatalf("expected decrypting at the wrong chunk to fail for size %d", test.size)
+			}
+		}
+	}
+}
+
+func TestReadEncrypted(t *testing.T) {
+	file, removeKey := newKeyFile(t)
+	defer removeKey()
+	encrypt := func(c *config.Config) {
+		c.EncryptionKeyFile = file
+	}
+	server, blobs, cleanup := newTestFS(t, encrypt)
+	defer cleanup()
+
+	ctx := context.Background()
+	data := make([]byte, 3*encryptionChunkSize+100)
+	rand.Read(data)
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "a.bin", Mode: 0600}
+	if err := server.fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := server.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: create.Entry.Child, Data: data}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: create.Entry.Child}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// Another mount downloads only the chunks covering each read.
+	reader, _, cleanupReader := newTestFS(t, encrypt)
+	defer cleanupReader()
+	reader.fs.inodes[fuseops.RootInodeID].container.url = blobs.ContainerURL()
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.bin"}
+	if err := reader.fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	blobs.Hook = func(r *http.Request) int {
+		if r.Method == http.MethodGet && r.Header.Get("x-ms-range") != "" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("x-ms-range"))
+			mu.Unlock()
+		}
+		return 0
+	}
+	for _, test := range []struct {
+		off    int64
+		length int
+		ranges []string
+	}{
+		{0, 10, []string{"bytes=0-65551"}},
+		{encryptionChunkSize - 5, 10, []string{"bytes=0-131103"}},
+		{3*encryptionChunkSize + 50, 100, []string{"bytes=196656-196771"}},
+		{int64(len(data)), 10, nil},
+	} {
+		mu.Lock()
+		ranges = nil
+		mu.Unlock()
+		op := &fuseops.ReadFileOp{Inode: lookUp.Entry.Child, Offset: test.off, Dst: make([]byte, test.length)}
+		if err := reader.fs.ReadFile(ctx, op); err != nil {
+			t.Fatalf("%d: unexpected err: %v", test.off, err)
+		}
+		expected := data[test.off:min64(test.off+int64(test.length), int64(len(data)))]
+		if !bytes.Equal(op.Dst[:op.BytesRead], expected) {
+			t.Fatalf("%d: expected %d bytes of the plaintext but got %d others", test.off, len(expected), op.BytesRead)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(ranges, test.ranges) {
+			t.Fatalf("%d: expected to download %v but got %v", test.off, test.ranges, ranges)
+		}
+		mu.Unlock()
+	}
+	if reader.fs.inodes[lookUp.Entry.Child].loaded {
+		t.Fatalf("expected a.bin not to be loaded")
+	}
+	blobs.Hook = nil
+
+	// Chunks can't be dropped or moved around.
+	b, _ := blobs.Get("a.bin")
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"dropped", b.Data[:3*sealedChunkSize]},
+		{"swapped", append(append(append([]byte(nil), b.Data[sealedChunkSize:2*sealedChunkSize]...), b.Data[:sealedChunkSize]...), b.Data[2*sealedChunkSize:]...)},
+	} {
+		blobs.Put(blobtest.Blob{Name: "a.bin", Data: test.data, Metadata: b.Metadata})
+		if _, err := readFile(t, blobs, encrypt, "a.bin"); err != fuse.EIO {
+			t.Fatalf("%s: expected EIO, but got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package fs
+
+import "sort"
+
+// extent is a half-open range [off, end) of a file which holds data. When the
+// file is held in memory, data holds the extent's contents.
+type extent struct {
+	off  int64
+	end  int64
+	data []byte
+}
+
+// extentMap is a sorted list of non-overlapping, non-adjacent extents. Any
+// range not covered by an extent is a hole and reads as zeros.
+type extentMap []extent
+
+// search returns the index of the first extent which ends at or after off.
+func (m extentMap) search(off int64) int {
+	return sort.Search(len(m), func(i int) bool {
+		return m[i].end >= off
+	})
+}
+
+// insert records [off, off+len(p)) as data, merging it with any extents it
+// overlaps or touches. If keepData is false only the range is recorded. It
+// returns the number of bytes by which the in-memory data grew.
+func (m *extentMap) insert(p []byte, off int64, keepData bool) (grown int64) {
+	if len(p) == 0 {
+		return
+	}
+
+	end := off + int64(len(p))
+	i := m.search(off)
+
+	// Find the extents which overlap or touch [off, end).
+	j := i
+	for j < len(*m) && (*m)[j].off <= end {
+		j++
+	}
+
+	merged := extent{off: off, end: end}
+	if i < j {
+		if (*m)[i].off < merged.off {
+			merged.off = (*m)[i].off
+		}
+		if (*m)[j-1].end > merged.end {
+			merged.end = (*m)[j-1].end
+		}
+	}
+
+	if keepData {
+		switch {
+		case j-i == 1 && (*m)[i].off <= off && end <= (*m)[i].end:
+			// Overwriting existing data can be done in place.
+			copy((*m)[i].data[off-(*m)[i].off:], p)
+			return 0
+		case j-i == 1 && (*m)[i].off <= off:
+			// Extending a single extent is the common case, so avoid copying it.
+			merged.data = append((*m)[i].data[:off-(*m)[i].off], p...)
+		default:
+			merged.data = make([]byte, merged.end-merged.off)
+			for _, e := range (*m)[i:j] {
+				copy(merged.data[e.off-merged.off:], e.data)
+			}
+			copy(merged.data[off-merged.off:], p)
+		}
+
+		grown = merged.end - merged.off
+		for _, e := range (*m)[i:j] {
+			grown -= e.end - e.off
+		}
+	}
+
+	*m = append((*m)[:i], append(extentMap{merged}, (*m)[j:]...)...)
+	return
+}
+
+// truncate drops everything at or beyond size. It returns the number of bytes
+// of in-memory data released.
+func (m *extentMap) truncate(size int64) (released int64) {
+	i := m.search(size)
+	if i < len(*m) && (*m)[i].off < size {
+		e := &(*m)[i]
+		if e.data != nil {
+			released += e.end - size
+			e.data = append([]byte(nil), e.data[:size-e.off]...)
+		}
+		e.end = size
+		i++
+	}
+
+	for _, e := range (*m)[i:] {
+		if e.data != nil {
+			released += e.end - e.off
+		}
+	}
+	*m = (*m)[:i]
+	return
+}
+
+// dropData forgets the in-memory data of every extent, keeping only ranges.
+func (m extentMap) dropData() {
+	for i := range m {
+		m[i].data = nil
+	}
+}
+
+// allocated returns the number of bytes covered by data.
+func (m extentMap) allocated() (n int64) {
+	for _, e := range m {
+		n += e.end - e.off
+	}
+	return
+}
+
+// seekData returns the first offset at or after off which holds data,
+// mirroring lseek(2) with SEEK_DATA. ok is false if there's no data after off.
+func (m extentMap) seekData(off int64) (pos int64, ok bool) {
+	i := m.search(off + 1)
+	if i == len(m) {
+		return 0, false
+	}
+	if m[i].off > off {
+		return m[i].off, true
+	}
+	return off, true
+}
+
+// seekHole returns the first offset at or after off which is in a hole,
+// mirroring lseek(2) with SEEK_HOLE. The end of the file counts as a hole.
+func (m extentMap) seekHole(off int64, size int64) int64 {
+	i := m.search(off + 1)
+	if i < len(m) && m[i].off <= off {
+		off = m[i].end
+	}
+	if off > size {
+		return size
+	}
+	return off
+}
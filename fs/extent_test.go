@@ -0,0 +1,65 @@
+package fs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtentMapInsert(t *testing.T) {
+	for _, test := range []struct {
+		writes   []extent
+		expected extentMap
+	}{
+		{
+			writes:   []extent{{off: 0, data: []byte("ab")}, {off: 2, data: []byte("cd")}},
+			expected: extentMap{{off: 0, end: 4, data: []byte("abcd")}},
+		},
+		{
+			writes:   []extent{{off: 0, data: []byte("ab")}, {off: 10, data: []byte("cd")}},
+			expected: extentMap{{off: 0, end: 2, data: []byte("ab")}, {off: 10, end: 12, data: []byte("cd")}},
+		},
+		{
+			writes:   []extent{{off: 0, data: []byte("ab")}, {off: 4, data: []byte("ef")}, {off: 1, data: []byte("xyz")}},
+			expected: extentMap{{off: 0, end: 6, data: []byte("axyzef")}},
+		},
+		{
+			writes:   []extent{{off: 0, data: []byte("abcd")}, {off: 1, data: []byte("x")}},
+			expected: extentMap{{off: 0, end: 4, data: []byte("axcd")}},
+		},
+	} {
+		var m extentMap
+		for _, w := range test.writes {
+			m.insert(w.data, w.off, true)
+		}
+		if !reflect.DeepEqual(test.expected, m) {
+			t.Fatalf("expected %+v but got %+v", test.expected, m)
+		}
+	}
+}
+
+func TestExtentMapSeek(t *testing.T) {
+	m := extentMap{{off: 10, end: 20}, {off: 30, end: 40}}
+	const size = 50
+
+	for _, test := range []struct {
+		off          int64
+		expectedData int64
+		expectedOK   bool
+		expectedHole int64
+	}{
+		{0, 10, true, 0},
+		{10, 10, true, 20},
+		{15, 15, true, 20},
+		{20, 30, true, 20},
+		{35, 35, true, 40},
+		{40, 0, false, 40},
+	} {
+		pos, ok := m.seekData(test.off)
+		if pos != test.expectedData || ok != test.expectedOK {
+			t.Fatalf("expected data at %v (%v) but got %v (%v) for offset %v", test.expectedData, test.expectedOK, pos, ok, test.off)
+		}
+		if hole := m.seekHole(test.off, size); hole != test.expectedHole {
+			t.Fatalf("expected hole at %v but got %v for offset %v", test.expectedHole, hole, test.off)
+		}
+	}
+}
@@ -2,19 +2,110 @@ package fs
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"reflect"
 	"time"
 
+	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 )
 
 type iNode struct {
+	// name is the name of the blob backing the inode.
+	name string
+
+	// parent is the directory containing the inode.
+	parent fuseops.InodeID
+
+	// container holds the blob backing the inode. It's nil for directories
+	// which only hold other mounts.
+	container *container
+
+	// account is set on a directory listing an account's containers.
+	account *azblob.ServiceURL
+
+	// snapshotsDir is the directory's .snapshots directory, once it's been
+	// looked up.
+	snapshotsDir fuseops.InodeID
+
+	// snapshots is set on .snapshots directories.
+	snapshots bool
+
+	// snapshot is the snapshot the inode belongs to, within a .snapshots
+	// directory.
+	snapshot string
+
+	// trashDir is the directory's .trash directory, once it's been looked up.
+	trashDir fuseops.InodeID
+
+	// trash is set on .trash directories.
+	trash bool
+
+	// deleted is set on soft deleted files within a .trash directory.
+	deleted bool
+
+	// etag is the ETag of the blob as of the last download or upload. It's
+	// empty if the blob hasn't been created yet.
+	etag azblob.ETag
+
+	// listed is set once a directory has been populated from its blobs.
+	listed bool
+
+	// loaded is set once a file's contents have been downloaded.
+	loaded bool
+
+	// validated is when the inode was last checked against its blob, or when
+	// a directory was last listed.
+	validated time.Time
+
+	// writers is the number of open handles which have written to a file.
+	writers int
+
+	// lease is held on the blob while there are writers, if enabled.
+	lease *lease
+
+	// blobType is the type of blob the inode is stored as.
+	blobType azblob.BlobType
+
+	// tier is the access tier of the blob, and archiveStatus the progress of
+	// rehydrating it if it's archived.
+	tier          azblob.AccessTierType
+	archiveStatus azblob.ArchiveStatusType
+
+	// exists is set once the backing blob has been created.
+	exists bool
+
+	// remoteSize is the size of the backing blob as of the last upload. For
+	// page blobs this is rounded up to a whole number of pages.
+	remoteSize int64
+
+	// unsyncedPages are the page aligned ranges of a page blob file which
+	// have been written locally but not uploaded yet.
+	unsyncedPages []pageRange
+
+	// chunks is the compressed length of each block of a compressed blob,
+	// and chunk the last block read from it, decompressed.
+	chunks     []int64
+	chunk      []byte
+	chunkIndex int
+
+	// keyMetadata holds the wrapped data key of an encrypted blob, so that it
+	// can be read a chunk at a time before it's loaded.
+	keyMetadata azblob.Metadata
+
+	// dirty is set when the contents have changed since they were last
+	// uploaded.
+	dirty bool
+
+	// transfer is set while a file's contents are being uploaded or
+	// downloaded without holding the file system's lock, and closed once
+	// they're done.
+	transfer chan struct{}
+
 	attrs    fuseops.InodeAttributes
 	entries  []fuseutil.Dirent
-	contents []byte
+	contents *fileBuffer
 	xattrs   map[string][]byte
 }
 
@@ -97,19 +188,7 @@ func (in *iNode) readAt(p []byte, off int64) (n int, err error) {
 		panic("readAt called on non-file.")
 	}
 
-	// Ensure the offset is in range.
-	if off > int64(len(in.contents)) {
-		err = io.EOF
-		return
-	}
-
-	// Read what we can.
-	n = copy(p, in.contents[off:])
-	if n < len(p) {
-		err = io.EOF
-	}
-
-	return
+	return in.contents.ReadAt(p, off)
 }
 
 func (in *iNode) addChild(
@@ -147,6 +226,29 @@ func (in *iNode) addChild(
 	in.entries = append(in.entries, e)
 }
 
+// renameChild renames the entry for a child. It returns false if there's no
+// such child.
+func (in *iNode) renameChild(oldName string, newName string) bool {
+	index, ok := in.findChild(oldName)
+	if ok {
+		in.entries[index].Name = newName
+	}
+	return ok
+}
+
+// removeChild removes the entry for a child, leaving a gap to be reused.
+func (in *iNode) removeChild(name string) {
+	index, ok := in.findChild(name)
+	if !ok {
+		return
+	}
+
+	in.attrs.Mtime = time.Now()
+	in.entries[index] = fuseutil.Dirent{
+		Offset: in.entries[index].Offset,
+	}
+}
+
 func (in *iNode) writeAt(p []byte, off int64) (n int, err error) {
 	if !in.isFile() {
 		panic("writeAt called on non-file.")
@@ -155,25 +257,28 @@ func (in *iNode) writeAt(p []byte, off int64) (n int, err error) {
 	// Update the modification time.
 	in.attrs.Mtime = time.Now()
 
-	// Ensure that the contents slice is long enough.
-	newLen := int(off) + len(p)
-	if len(in.contents) < newLen {
-		padding := make([]byte, newLen-len(in.contents))
-		in.contents = append(in.contents, padding...)
-		in.attrs.Size = uint64(newLen)
+	// The buffer grows as needed, spilling to disk if it gets too large.
+	n, err = in.contents.WriteAt(p, off)
+	in.attrs.Size = uint64(in.contents.Size())
+	in.dirty = true
+	if err != nil {
+		return
 	}
 
-	// Copy in the data.
-	n = copy(in.contents[off:], p)
-
 	// Sanity check.
 	if n != len(p) {
-		panic(fmt.Sprintf("Unexpected short copy: %v", n))
+		panic(fmt.Sprintf("Unexpected short write: %v", n))
 	}
 
 	return
 }
 
+// isImmutable reports whether an inode is part of a .snapshots or .trash
+// directory, none of which can be changed.
+func (in *iNode) isImmutable() bool {
+	return in.snapshots || in.snapshot != "" || in.trash || in.deleted
+}
+
 func (in *iNode) isDir() bool {
 	return in.attrs.Mode&os.ModeDir != 0
 }
@@ -190,6 +295,23 @@ func (in *iNode) isFile() bool {
 func (in *iNode) setAttributes(
 	size *uint64,
 	mode *os.FileMode,
-	mtime *time.Time) {
-	// TODO
+	mtime *time.Time) (err error) {
+	if size != nil && in.isFile() {
+		if err = in.contents.Truncate(int64(*size)); err != nil {
+			return
+		}
+		in.attrs.Size = *size
+		in.attrs.Mtime = time.Now()
+		in.dirty = true
+	}
+
+	if mode != nil {
+		in.attrs.Mode = (in.attrs.Mode &^ os.ModePerm) | (*mode & os.ModePerm)
+	}
+
+	if mtime != nil {
+		in.attrs.Mtime = *mtime
+	}
+
+	return
 }
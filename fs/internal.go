@@ -1,10 +1,16 @@
 package fs
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"path"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
@@ -23,12 +29,6 @@ func (fs *lightningFS) getINode(id fuseops.InodeID) (*iNode, error) {
 	return inode, nil
 }
 
-// getDefaultAttributesExpiration returns a default attributes expiration time.
-// We allow the kernel to cache as long as it wants to and handle invalidation.
-func getDefaultAttributesExpiration() time.Time {
-	return time.Now().Add(365 * 24 * time.Hour)
-}
-
 func (fs *lightningFS) createFile(
 	parentID fuseops.InodeID,
 	name string,
@@ -39,6 +39,13 @@ func (fs *lightningFS) createFile(
 		return entry, err
 	}
 
+	// Directories which only hold other mounts can't hold files, and chunks
+	// are only written by syncing.
+	if parent.container == nil || fs.isChunksDir(parent, name) {
+		err = syscall.EPERM
+		return
+	}
+
 	// Don't create a duplicate
 	_, _, exists := parent.LookUpChild(name)
 	if exists {
@@ -59,12 +66,19 @@ func (fs *lightningFS) createFile(
 	}
 
 	childID, child := fs.allocateInode(childAttrs)
+	child.name = path.Join(parent.name, name)
+	child.parent = parentID
+	child.container = parent.container
+	child.loaded = true
+	child.blobType = fs.blobTypeFor(parent.container.relativeName(child.name))
+	child.contents = newFileBuffer(fs.stager)
+	child.dirty = true
 	parent.addChild(childID, name, fuseutil.DT_File)
 
 	entry.Child = childID
 	entry.Attributes = child.attrs
-	entry.AttributesExpiration = getDefaultAttributesExpiration()
-	entry.EntryExpiration = entry.AttributesExpiration
+	entry.AttributesExpiration = fs.attributesExpiration()
+	entry.EntryExpiration = fs.entryExpiration()
 	return
 }
 
@@ -75,3 +89,110 @@ func (fs *lightningFS) allocateInode(attrs fuseops.InodeAttributes) (id fuseops.
 	fs.inodes = append(fs.inodes, inode)
 	return
 }
+
+// blobTypeFor returns the type of blob a new file should be stored as. A
+// pattern matches if it matches either the full name or the base name.
+func (fs *lightningFS) blobTypeFor(name string) azblob.BlobType {
+	if matchAny(fs.pageBlobPatterns, name) {
+		return azblob.BlobPageBlob
+	}
+	if matchAny(fs.appendBlobPatterns, name) {
+		return azblob.BlobAppendBlob
+	}
+	return azblob.BlobBlockBlob
+}
+
+// matchAny reports whether name or its base name matches any of the patterns.
+func matchAny(patterns []string, name string) bool {
+	base := path.Base(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix validates the blob prefix mounted as the root, stripping any
+// leading or trailing delimiters.
+func parsePrefix(prefix string) (string, error) {
+	prefix = strings.Trim(prefix, blobDelimiter)
+	if prefix == "" {
+		return "", nil
+	}
+	if path.Clean(prefix) != prefix || prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return "", fmt.Errorf("invalid prefix: %q", prefix)
+	}
+	return prefix, nil
+}
+
+// fileHandle is an open handle to a file.
+type fileHandle struct {
+	inode fuseops.InodeID
+
+	// writing is set once the handle has been written through.
+	writing bool
+}
+
+func (fs *lightningFS) allocateHandle(id fuseops.InodeID) fuseops.HandleID {
+	fs.nextHandle++
+	fs.handles[fs.nextHandle] = &fileHandle{inode: id}
+	return fs.nextHandle
+}
+
+// startWriting records that a handle is being written through, leasing the
+// file's blob if this is its first writer. Writes fail once the lease has been
+// lost.
+func (fs *lightningFS) startWriting(ctx context.Context, handleID fuseops.HandleID, in *iNode) error {
+	if in.lease != nil && in.lease.isLost() {
+		log.Printf("failed to write %s: its lease couldn't be renewed", in.name)
+		return syscall.EIO
+	}
+
+	// Only writers which are counted release the lease, so nothing else may
+	// acquire it.
+	h, ok := fs.handles[handleID]
+	if !ok || h.writing {
+		return nil
+	}
+
+	if err := fs.acquireLease(ctx, in); err != nil {
+		return err
+	}
+	h.writing = true
+	in.writers++
+	return nil
+}
+
+// releaseHandle closes a handle. Once a file's last writer is gone, the file
+// is uploaded and its lease released.
+func (fs *lightningFS) releaseHandle(ctx context.Context, handleID fuseops.HandleID) error {
+	h, ok := fs.handles[handleID]
+	if !ok {
+		return nil
+	}
+	delete(fs.handles, handleID)
+	if !h.writing {
+		return nil
+	}
+
+	in, err := fs.getINode(h.inode)
+	if err != nil {
+		return err
+	}
+	in.writers--
+	if in.writers > 0 {
+		return nil
+	}
+
+	// Release the lease even if the upload failed so that it isn't held
+	// forever.
+	serr := fs.syncFile(ctx, in)
+	if err := fs.releaseLease(ctx, in); err != nil && serr == nil {
+		return err
+	}
+	return serr
+}
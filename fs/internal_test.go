@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestParsePrefix(t *testing.T) {
+	for _, test := range []struct {
+		prefix   string
+		expected string
+		err      bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"datasets/imagenet/", "datasets/imagenet", false},
+		{"/datasets", "datasets", false},
+		{"datasets//imagenet", "", true},
+		{"datasets/../imagenet", "", true},
+		{"..", "", true},
+		{"./datasets", "", true},
+	} {
+		actual, err := parsePrefix(test.prefix)
+		if (err != nil) != test.err {
+			t.Fatalf("expected error %v but got %v for %q", test.err, err, test.prefix)
+		}
+		if actual != test.expected {
+			t.Fatalf("expected %q but got %q for %q", test.expected, actual, test.prefix)
+		}
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	for _, test := range []struct {
+		patterns []string
+		name     string
+		expected bool
+	}{
+		{nil, "a.vhd", false},
+		{[]string{"*.vhd"}, "a.vhd", true},
+		{[]string{"*.vhd"}, "images/a.vhd", true},
+		{[]string{"images/*"}, "images/a.vhd", true},
+		{[]string{"images/*"}, "a.vhd", false},
+		{[]string{"*.db", "*.vhd"}, "a.txt", false},
+	} {
+		if actual := matchAny(test.patterns, test.name); actual != test.expected {
+			t.Fatalf("expected %v but got %v for %v matching %s", test.expected, actual, test.patterns, test.name)
+		}
+	}
+}
+
+func TestStartWriting(t *testing.T) {
+	fs := &lightningFS{leaseWrites: true, handles: make(map[fuseops.HandleID]*fileHandle)}
+	in := &iNode{name: "a.txt"}
+	writing := fs.allocateHandle(1)
+	fs.handles[writing].writing = true
+
+	// Neither an unknown handle nor one already writing counts as a new
+	// writer, so neither may lease the blob, which would upload it first.
+	for _, handle := range []fuseops.HandleID{writing, 42} {
+		if err := fs.startWriting(context.Background(), handle, in); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if in.writers != 0 {
+			t.Fatalf("expected no writers to be counted, but got %d", in.writers)
+		}
+	}
+
+	in.lease = &lease{lost: 1}
+	if err := fs.startWriting(context.Background(), writing, in); err != syscall.EIO {
+		t.Fatalf("expected EIO once the lease is lost, but got %v", err)
+	}
+}
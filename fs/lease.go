@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/internal/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	// leaseDuration is the length of the leases taken out on blobs, in
+	// seconds. Leases are renewed well before they expire.
+	leaseDuration = 60
+
+	// leaseRenewInterval is how often leases are renewed.
+	leaseRenewInterval = 20 * time.Second
+)
+
+// lease is a lease held on a blob while a file is being written.
+type lease struct {
+	id   string
+	stop chan struct{}
+	done chan struct{}
+
+	// lost is set once the lease couldn't be renewed, after which writes fail
+	// rather than carry on without excluding other mounts. It's accessed
+	// atomically.
+	lost int32
+}
+
+// isLost reports whether the lease couldn't be renewed.
+func (l *lease) isLost() bool {
+	return atomic.LoadInt32(&l.lost) != 0
+}
+
+// renew keeps the lease alive until it's stopped.
+func (l *lease) renew(blobURL azblob.BlobURL) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if _, err := blobURL.RenewLease(context.Background(), l.id, azblob.ModifiedAccessConditions{}); err != nil {
+				log.Printf("failed to renew lease on %s: %v", blobURL.String(), err)
+				atomic.StoreInt32(&l.lost, 1)
+			}
+		}
+	}
+}
+
+// newLeaseID returns a random (version 4) UUID to propose as a lease ID.
+func newLeaseID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// isLeased reports whether err was caused by someone else holding a lease on
+// a blob.
+func isLeased(err error) bool {
+	serr, ok := storage.AsError(err)
+	if !ok {
+		return false
+	}
+	switch serr.ServiceCode() {
+	case azblob.ServiceCodeLeaseAlreadyPresent, azblob.ServiceCodeLeaseIDMissing:
+		return true
+	}
+	return false
+}
+
+// acquireLease takes out a lease on a file's blob, stopping other mounts from
+// writing to it, and keeps it renewed until it's released. A blob has to exist
+// to be leased, so new files are uploaded first.
+func (fs *lightningFS) acquireLease(ctx context.Context, in *iNode) error {
+	if !fs.leaseWrites || in.lease != nil {
+		return nil
+	}
+	if !in.exists {
+		if err := fs.syncFile(ctx, in); err != nil {
+			return err
+		}
+	}
+
+	id, err := newLeaseID()
+	if err != nil {
+		return errors.Wrap(err, "failed to generate lease ID")
+	}
+
+	blobURL := in.container.url.NewBlobURL(in.name)
+	resp, err := blobURL.AcquireLease(ctx, id, leaseDuration, azblob.ModifiedAccessConditions{})
+	if isLeased(err) {
+		log.Printf("%s is being written by another mount", in.name)
+		return syscall.EBUSY
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to acquire lease on %s", in.name)
+	}
+
+	in.lease = &lease{
+		id:   resp.LeaseID(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go in.lease.renew(blobURL)
+	return nil
+}
+
+// releaseLease releases the lease on a file's blob, if any.
+func (fs *lightningFS) releaseLease(ctx context.Context, in *iNode) error {
+	if in.lease == nil {
+		return nil
+	}
+
+	l := in.lease
+	in.lease = nil
+	close(l.stop)
+	<-l.done
+
+	blobURL := in.container.url.NewBlobURL(in.name)
+	if _, err := blobURL.ReleaseLease(ctx, l.id, azblob.ModifiedAccessConditions{}); err != nil {
+		return errors.Wrapf(err, "failed to release lease on %s", in.name)
+	}
+	return nil
+}
+
+// leaseAccessConditions returns the lease conditions writes to a file's blob
+// must carry.
+func (in *iNode) leaseAccessConditions() azblob.LeaseAccessConditions {
+	if in.lease == nil {
+		return azblob.LeaseAccessConditions{}
+	}
+	return azblob.LeaseAccessConditions{LeaseID: in.lease.id}
+}
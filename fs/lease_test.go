@@ -0,0 +1,49 @@
+package fs
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestAcquireLease(t *testing.T) {
+	leaseWrites := func(c *config.Config) { c.LeaseWrites = true }
+	first, blobs, cleanup := newTestFS(t, leaseWrites)
+	defer cleanup()
+	second, _, cleanupSecond := newTestFS(t, leaseWrites)
+	defer cleanupSecond()
+	second.fs.inodes[fuseops.RootInodeID].container.url = blobs.ContainerURL()
+
+	ctx := context.Background()
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "a.txt", Mode: 0600}
+	if err := first.fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := first.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: create.Entry.Child, Handle: create.Handle, Data: []byte("first")}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.txt"}
+	if err := second.fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	open := &fuseops.OpenFileOp{Inode: lookUp.Entry.Child}
+	if err := second.fs.OpenFile(ctx, open); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	write := &fuseops.WriteFileOp{Inode: lookUp.Entry.Child, Handle: open.Handle, Data: []byte("second")}
+	if err := second.fs.WriteFile(ctx, write); err != syscall.EBUSY {
+		t.Fatalf("expected EBUSY while another mount is writing, but got %v", err)
+	}
+
+	// Once the first writer is done, the lease is released.
+	if err := first.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: create.Handle}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if b, _ := blobs.Get("a.txt"); string(b.Data) != "first" || b.LeaseID != "" {
+		t.Fatalf("expected first to be uploaded and the lease released, but got %q leased by %q", b.Data, b.LeaseID)
+	}
+}
@@ -2,48 +2,77 @@ package fs
 
 import (
 	"context"
-	"fmt"
 	"io"
-	"net/url"
+	"log"
 	"os"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/credential"
+	"github.com/ehotinger/lightningfs/defaults"
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 	"github.com/pkg/errors"
 )
 
-const (
-	blobFmt = "https://%s.blob.core.windows.net/%s"
-)
+func NewLightningFS(config *config.Config, uid uint32, gid uint32) (*Server, error) {
+	// Check everything up front, e.g. that each mount has credentials, rather
+	// than failing on first access.
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	stagingPath := config.StagingPath
+	if stagingPath == "" {
+		stagingPath = config.CachePath
+	}
+	memoryBudget := config.MemoryBudget
+	if memoryBudget <= 0 {
+		memoryBudget = defaults.MemoryBudget
+	}
 
-func NewLightningFS(config *config.Config, uid uint32, gid uint32) (server fuse.Server, err error) {
-	// TODO: SAS support
-	credential, err := azblob.NewSharedKeyCredential(config.AzureAccountName, config.AzureAccountKey)
+	conflictPolicy, err := parseConflictPolicy(config.ConflictPolicy)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create shared key credential")
+		return nil, err
 	}
 
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{
-		Retry: azblob.RetryOptions{}, // TODO: retries
-	})
+	var encryptionKey *encryptionKey
+	if config.EncryptionKeyFile != "" {
+		if encryptionKey, err = loadEncryptionKey(config.EncryptionKeyFile); err != nil {
+			return nil, err
+		}
+	}
 
-	cURL, err := url.Parse(fmt.Sprintf(blobFmt, config.AzureAccountName, config.ContainerName))
+	defaultTier, err := parseTier(config.DefaultTier)
 	if err != nil {
 		return nil, err
 	}
 
-	containerURL := azblob.NewContainerURL(*cURL, p)
+	attributeTTL, entryTTL := ttls(config)
 
 	fs := &lightningFS{
-		containerURL: containerURL,
-		inodes:       make([]*iNode, fuseops.RootInodeID+1),
-		uid:          uid,
-		gid:          gid,
+		stager:             newStager(stagingPath, memoryBudget),
+		containers:         containerNames(config),
+		pageBlobPatterns:   config.PageBlobPatterns,
+		appendBlobPatterns: config.AppendBlobPatterns,
+		compressPatterns:   config.CompressPatterns,
+		conflictPolicy:     conflictPolicy,
+		leaseWrites:        config.LeaseWrites,
+		defaultTier:        defaultTier,
+		encryptionKey:      encryptionKey,
+		dedup:              config.Dedup,
+		readOnly:           config.ReadOnly,
+		attributeTTL:       attributeTTL,
+		entryTTL:           entryTTL,
+		negativeTTL:        config.NegativeTTL,
+		handles:            make(map[fuseops.HandleID]*fileHandle),
+		inodes:             make([]*iNode, fuseops.RootInodeID+1),
+		uid:                uid,
+		gid:                gid,
 	}
 
 	now := time.Now()
@@ -58,18 +87,92 @@ func NewLightningFS(config *config.Config, uid uint32, gid uint32) (server fuse.
 			Atime:  now,
 		},
 	)
+	if err := fs.mountRoot(config); err != nil {
+		return nil, err
+	}
+
+	fs.config = config
+	fs.startPolling(config.PollInterval)
 
-	server = fuseutil.NewFileSystemServer(fs)
-	return server, nil
+	return &Server{Server: fuseutil.NewFileSystemServer(fs), fs: fs}, nil
 }
 
-type lightningFS struct {
-	containerURL azblob.ContainerURL
+// ttls returns how long the kernel may cache attributes and directory entries
+// for.
+func ttls(config *config.Config) (attributeTTL time.Duration, entryTTL time.Duration) {
+	attributeTTL, entryTTL = defaults.AttributeTTL, defaults.EntryTTL
+	if config.ReadOnly {
+		attributeTTL, entryTTL = defaults.ReadOnlyTTL, defaults.ReadOnlyTTL
+	}
+	return durationOrDefault(config.AttributeTTL, attributeTTL), durationOrDefault(config.EntryTTL, entryTTL)
+}
 
-	mu     sync.RWMutex
-	inodes []*iNode
-	uid    uint32
-	gid    uint32
+type lightningFS struct {
+	stager *stager
+
+	// containers describes the containers mounted. It never changes, so it
+	// can be read without holding mu.
+	containers []string
+
+	// containerRoots are the directories at which containers are mounted.
+	containerRoots []fuseops.InodeID
+
+	// config is the config last applied, and credentials authorize the
+	// requests of each of its mounts in turn. Both change on reload.
+	config      *config.Config
+	credentials []*credential.Reloadable
+
+	// pageBlobPatterns and appendBlobPatterns are globs of files stored as
+	// page and append blobs respectively.
+	pageBlobPatterns   []string
+	appendBlobPatterns []string
+
+	// compressPatterns are globs of files to compress, if they're stored as
+	// block blobs.
+	compressPatterns []string
+
+	// conflictPolicy decides how to handle uploads of blobs which were changed
+	// by someone else.
+	conflictPolicy string
+
+	// leaseWrites enables leasing blobs while they're being written.
+	leaseWrites bool
+
+	// defaultTier is the access tier new block blobs are moved to, if set.
+	defaultTier azblob.AccessTierType
+
+	// encryptionKey encrypts the contents of files before they're uploaded,
+	// if set.
+	encryptionKey *encryptionKey
+
+	// dedup stores files as manifests of content-defined chunks.
+	dedup bool
+
+	// readOnly rejects all changes.
+	readOnly bool
+
+	// draining is set once the file system is shutting down, after which
+	// changes are rejected too. It's accessed atomically.
+	draining int32
+
+	// attributeTTL, entryTTL and negativeTTL are how long the kernel may cache
+	// attributes, directory entries and failed lookups. They're also how long
+	// we go without checking for changes made by someone else.
+	attributeTTL time.Duration
+	entryTTL     time.Duration
+	negativeTTL  time.Duration
+
+	// stopPolling stops the background poller, if any, and pollDone is closed
+	// once it has exited. They're protected by mu.
+	stopPolling chan struct{}
+	pollDone    chan struct{}
+
+	mu         sync.RWMutex
+	inodes     []*iNode
+	handles    map[fuseops.HandleID]*fileHandle
+	nextHandle fuseops.HandleID
+	uid        uint32
+	gid        uint32
 }
 
 // Statfs obtains the file system's metadata.
@@ -90,10 +193,32 @@ func (fs *lightningFS) LookUpInode(
 	if err != nil {
 		return err
 	}
+	if err = fs.listDir(ctx, op.Parent, parent); err != nil {
+		return err
+	}
+
+	var (
+		hiddenID fuseops.InodeID
+		hidden   *iNode
+		ok       bool
+	)
+	switch op.Name {
+	case snapshotsDirName:
+		hiddenID, hidden, ok = fs.lookUpSnapshots(op.Parent, parent)
+	case trashDirName:
+		hiddenID, hidden, ok = fs.lookUpTrash(op.Parent, parent)
+	}
+	if ok {
+		op.Entry.Child = hiddenID
+		op.Entry.Attributes = hidden.attrs
+		op.Entry.AttributesExpiration = fs.attributesExpiration()
+		op.Entry.EntryExpiration = fs.entryExpiration()
+		return nil
+	}
 
 	childID, _, ok := parent.LookUpChild(op.Name)
 	if !ok {
-		return fuse.ENOENT
+		return fs.lookUpMissing(op)
 	}
 
 	child, err := fs.getINode(childID)
@@ -101,10 +226,19 @@ func (fs *lightningFS) LookUpInode(
 		return err
 	}
 
+	found, err := fs.revalidate(ctx, child)
+	if err != nil {
+		return err
+	}
+	if !found {
+		parent.removeChild(op.Name)
+		return fs.lookUpMissing(op)
+	}
+
 	op.Entry.Child = childID
 	op.Entry.Attributes = child.attrs
-	op.Entry.AttributesExpiration = getDefaultAttributesExpiration()
-	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
+	op.Entry.AttributesExpiration = fs.attributesExpiration()
+	op.Entry.EntryExpiration = fs.entryExpiration()
 	return nil
 }
 
@@ -119,8 +253,16 @@ func (fs *lightningFS) GetInodeAttributes(
 		return err
 	}
 
+	found, err := fs.revalidate(ctx, inode)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fuse.ENOENT
+	}
+
 	op.Attributes = inode.attrs
-	op.AttributesExpiration = getDefaultAttributesExpiration()
+	op.AttributesExpiration = fs.attributesExpiration()
 	return nil
 }
 
@@ -130,14 +272,53 @@ func (fs *lightningFS) SetInodeAttributes(
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
 	inode, err := fs.getINode(op.Inode)
 	if err != nil {
 		return err
 	}
+	if inode.isImmutable() {
+		return syscall.EROFS
+	}
 
-	inode.setAttributes(op.Size, op.Mode, op.Mtime)
+	if op.Size != nil && inode.isFile() {
+		// There's no need to download what's about to be thrown away.
+		if *op.Size == 0 {
+			fs.waitTransfer(inode)
+			inode.loaded = true
+		}
+		if err = fs.prepareWrite(ctx, inode); err != nil {
+			return err
+		}
+	}
+	var oldSize int64
+	if inode.contents != nil {
+		oldSize = inode.contents.Size()
+	}
+	if op.Size != nil && inode.blobType == azblob.BlobAppendBlob {
+		if err = fs.truncateAppendBlob(inode, *op.Size); err != nil {
+			return err
+		}
+	}
+	if err = inode.setAttributes(op.Size, op.Mode, op.Mtime); err != nil {
+		return err
+	}
+	if op.Size != nil {
+		switch {
+		case inode.blobType == azblob.BlobPageBlob && inode.exists:
+			err = fs.truncatePageBlob(ctx, inode, oldSize)
+		case inode.blobType == azblob.BlobAppendBlob:
+			err = fs.syncAppendBlob(ctx, inode)
+		}
+		if err != nil {
+			return err
+		}
+	}
 	op.Attributes = inode.attrs
-	op.AttributesExpiration = getDefaultAttributesExpiration()
+	op.AttributesExpiration = fs.attributesExpiration()
 	return nil
 }
 
@@ -150,12 +331,18 @@ func (fs *lightningFS) ForgetInode(
 func (fs *lightningFS) MkDir(
 	ctx context.Context,
 	op *fuseops.MkDirOp) error {
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
 func (fs *lightningFS) MkNode(
 	ctx context.Context,
 	op *fuseops.MkNodeOp) error {
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
@@ -165,38 +352,87 @@ func (fs *lightningFS) CreateFile(
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	var err error
-	op.Entry, err = fs.createFile(op.Parent, op.Name, op.Mode)
-	return err
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
+	parent, err := fs.getINode(op.Parent)
+	if err != nil {
+		return err
+	}
+	if parent.isImmutable() {
+		return syscall.EROFS
+	}
+	if err = fs.listDir(ctx, op.Parent, parent); err != nil {
+		return err
+	}
+	// Listing released the lock, after which Shutdown may have flushed.
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
+	if op.Entry, err = fs.createFile(op.Parent, op.Name, op.Mode); err != nil {
+		return err
+	}
+	op.Handle = fs.allocateHandle(op.Entry.Child)
+	return nil
 }
 
 func (fs *lightningFS) CreateSymlink(
 	ctx context.Context,
 	op *fuseops.CreateSymlinkOp) error {
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
 func (fs *lightningFS) CreateLink(
 	ctx context.Context,
 	op *fuseops.CreateLinkOp) error {
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
 func (fs *lightningFS) Rename(
 	ctx context.Context,
 	op *fuseops.RenameOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
+	oldParent, err := fs.getINode(op.OldParent)
+	if err != nil {
+		return err
+	}
+
+	// Moving a file out of .trash restores it.
+	if oldParent.trash {
+		return fs.restore(ctx, oldParent, op.OldName, op.NewParent, op.NewName)
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
 func (fs *lightningFS) RmDir(
 	ctx context.Context,
 	op *fuseops.RmDirOp) error {
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
 func (fs *lightningFS) Unlink(
 	ctx context.Context,
 	op *fuseops.UnlinkOp) error {
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
 	return fuse.ENOSYS // TODO: Unimplemented
 }
 
@@ -215,7 +451,7 @@ func (fs *lightningFS) OpenDir(
 		return errors.New("node is not a directory")
 	}
 
-	return nil
+	return fs.listDir(ctx, op.Inode, inode)
 }
 
 func (fs *lightningFS) ReadDir(
@@ -228,6 +464,9 @@ func (fs *lightningFS) ReadDir(
 	if err != nil {
 		return err
 	}
+	if err = fs.listDir(ctx, op.Inode, inode); err != nil {
+		return err
+	}
 
 	op.BytesRead = inode.readDir(op.Dst, int(op.Offset))
 	return
@@ -243,7 +482,28 @@ func (fs *lightningFS) ReleaseDirHandle(
 func (fs *lightningFS) OpenFile(
 	ctx context.Context,
 	op *fuseops.OpenFileOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	// Make sure opening a file sees changes made by someone else.
+	found, err := fs.revalidate(ctx, inode)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fuse.ENOENT
+	}
+
+	// Nothing changes on a read-only mount, so there's no need to drop what
+	// the kernel has cached.
+	op.KeepPageCache = fs.readOnly
+	op.Handle = fs.allocateHandle(op.Inode)
+	return nil
 }
 func (fs *lightningFS) ReadFile(
 	ctx context.Context,
@@ -255,6 +515,21 @@ func (fs *lightningFS) ReadFile(
 	if err != nil {
 		return err
 	}
+
+	// Compressed and encrypted files can be read a block or a chunk at a time
+	// without loading them.
+	if !inode.loaded && inode.chunks != nil {
+		op.BytesRead, err = fs.readCompressed(ctx, inode, op.Dst, op.Offset)
+		return
+	}
+	if !inode.loaded && inode.keyMetadata != nil {
+		op.BytesRead, err = fs.readEncrypted(ctx, inode, op.Dst, op.Offset)
+		return
+	}
+
+	if err = fs.loadFile(ctx, inode); err != nil {
+		return err
+	}
 	op.BytesRead, err = inode.readAt(op.Dst, op.Offset)
 
 	// Don't return EOF errors; we just indicate EOF to fuse using a short read.
@@ -271,30 +546,81 @@ func (fs *lightningFS) WriteFile(
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
 	inode, err := fs.getINode(op.Inode)
 	if err != nil {
 		return err
 	}
-	_, err = inode.writeAt(op.Data, op.Offset)
+	if inode.isImmutable() {
+		return syscall.EROFS
+	}
+	if err = fs.startWriting(ctx, op.Handle, inode); err != nil {
+		return err
+	}
+	if err = fs.prepareWrite(ctx, inode); err != nil {
+		return err
+	}
+	if err = fs.checkEncryptable(inode); err != nil {
+		return err
+	}
+	if inode.blobType == azblob.BlobAppendBlob {
+		if err = checkAppend(inode, op.Data, op.Offset); err != nil {
+			return err
+		}
+	}
+
+	n, err := inode.writeAt(op.Data, op.Offset)
+	if err != nil {
+		return err
+	}
+
+	// Page and append blobs are written through so that in place edits and
+	// appends are durable immediately.
+	switch inode.blobType {
+	case azblob.BlobPageBlob:
+		err = fs.writePages(ctx, inode, op.Offset, op.Offset+int64(n))
+	case azblob.BlobAppendBlob:
+		err = fs.syncAppendBlob(ctx, inode)
+	}
 	return
 }
 
 func (fs *lightningFS) SyncFile(
 	ctx context.Context,
 	op *fuseops.SyncFileOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+	return fs.syncFile(ctx, inode)
 }
 
 func (fs *lightningFS) FlushFile(
 	ctx context.Context,
 	op *fuseops.FlushFileOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+	return fs.syncFile(ctx, inode)
 }
 
 func (fs *lightningFS) ReleaseFileHandle(
 	ctx context.Context,
 	op *fuseops.ReleaseFileHandleOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.releaseHandle(ctx, op.Handle)
 }
 
 func (fs *lightningFS) ReadSymlink(
@@ -306,25 +632,102 @@ func (fs *lightningFS) ReadSymlink(
 func (fs *lightningFS) RemoveXattr(
 	ctx context.Context,
 	op *fuseops.RemoveXattrOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+	if inode.isImmutable() {
+		return syscall.EROFS
+	}
+	return fs.removeXattr(inode, op.Name)
 }
 
 func (fs *lightningFS) GetXattr(
 	ctx context.Context,
 	op *fuseops.GetXattrOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	value, err := fs.getXattr(inode, op.Name)
+	if err != nil {
+		return err
+	}
+	op.BytesRead, err = copyXattr(op.Dst, value)
+	return
 }
 
 func (fs *lightningFS) ListXattr(
 	ctx context.Context,
 	op *fuseops.ListXattrOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	// Names are NUL terminated.
+	var names []byte
+	for _, name := range fs.listXattrs(inode) {
+		names = append(names, name...)
+		names = append(names, 0)
+	}
+	op.BytesRead, err = copyXattr(op.Dst, names)
+	return
 }
 
 func (fs *lightningFS) SetXattr(
 	ctx context.Context,
 	op *fuseops.SetXattrOp) (err error) {
-	return fuse.ENOSYS // TODO: Unimplemented
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+
+	inode, err := fs.getINode(op.Inode)
+	if err != nil {
+		return err
+	}
+	if inode.isImmutable() {
+		return syscall.EROFS
+	}
+	return fs.setXattr(ctx, inode, op.Name, op.Value)
 }
 
-func (fs *lightningFS) Destroy() {}
+func (fs *lightningFS) Destroy() {
+	fs.mu.Lock()
+	stopped := fs.startPolling(0)
+
+	// Every dirty file is uploaded, including those which were never flushed,
+	// e.g. truncated by path, and any leases held are released.
+	for _, inode := range fs.inodes {
+		if inode == nil || !inode.isFile() {
+			continue
+		}
+		if err := fs.syncFile(context.Background(), inode); err != nil {
+			log.Printf("failed to flush %s: %v", inode.name, err)
+		}
+		if err := fs.releaseLease(context.Background(), inode); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	fs.mu.Unlock()
+
+	// The poller can't finish a poll without the lock.
+	<-stopped
+}
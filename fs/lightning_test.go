@@ -0,0 +1,170 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/defaults"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// newTestFS returns a file system mounting the container of a fake Blob
+// service, with its cache in a temporary directory. change, if set, adjusts
+// the config first; if it sets up mounts, they're left as configured. The
+// returned function cleans up.
+func newTestFS(t *testing.T, change func(c *config.Config)) (*Server, *blobtest.Server, func()) {
+	dir, err := ioutil.TempDir("", "lightningfs")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	cfg := config.NewConfig("account", "a2V5", "container", dir)
+	if change != nil {
+		change(cfg)
+	}
+	server, err := NewLightningFS(cfg, 0, 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	blobs := blobtest.NewServer()
+	if root := server.fs.inodes[fuseops.RootInodeID]; root.container != nil {
+		root.container.url = blobs.ContainerURL()
+	}
+	return server, blobs, func() {
+		blobs.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// readFile reads a file back through a fresh mount of the fake's container.
+func readFile(t *testing.T, blobs *blobtest.Server, change func(c *config.Config), name string) ([]byte, error) {
+	server, _, cleanup := newTestFS(t, change)
+	defer cleanup()
+	server.fs.inodes[fuseops.RootInodeID].container.url = blobs.ContainerURL()
+
+	ctx := context.Background()
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+	if err := server.fs.LookUpInode(ctx, lookUp); err != nil {
+		return nil, err
+	}
+	op := &fuseops.ReadFileOp{Inode: lookUp.Entry.Child, Dst: make([]byte, lookUp.Entry.Attributes.Size+1)}
+	err := server.fs.ReadFile(ctx, op)
+	return op.Dst[:op.BytesRead], err
+}
+
+// lookUp returns the inode of a child of a directory, or nil if there isn't
+// one.
+func lookUp(fs *lightningFS, dir *iNode, name string) *iNode {
+	id, _, ok := dir.LookUpChild(name)
+	if !ok {
+		return nil
+	}
+	return fs.inodes[id]
+}
+
+func TestNewLightningFS(t *testing.T) {
+	key := config.Credentials{AzureAccountName: "a", AzureAccountKey: "a2V5"}
+	for _, test := range []struct {
+		config      *config.Config
+		shouldError bool
+	}{
+		{config.NewConfig("a", "a2V5", "c", ""), false},
+		{config.NewConfig("a", "", "c", ""), true},
+		{&config.Config{Mounts: []config.Mount{{Credentials: key, ContainerName: "c"}, {Credentials: key, Name: "d"}}}, false},
+		// Each mount needs credentials of its own.
+		{&config.Config{Mounts: []config.Mount{{Credentials: key, ContainerName: "c"}, {Credentials: config.Credentials{AzureAccountName: "b"}, ContainerName: "d"}}}, true},
+	} {
+		_, err := NewLightningFS(test.config, 0, 0)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatal("expected test to error, but it didn't")
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, func(c *config.Config) { c.ReadOnly = true })
+	defer cleanup()
+	fs := server.fs
+
+	ctx := context.Background()
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("a")})
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.txt"}
+	if err := fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := lookUp.Entry.Child
+
+	// Nothing changes, so the kernel may cache everything for longer.
+	expiration := time.Now().Add(defaults.ReadOnlyTTL - time.Minute)
+	if lookUp.Entry.EntryExpiration.Before(expiration) || lookUp.Entry.AttributesExpiration.Before(expiration) {
+		t.Fatalf("expected entries and attributes to be cached for %v", defaults.ReadOnlyTTL)
+	}
+	open := &fuseops.OpenFileOp{Inode: id}
+	if err := fs.OpenFile(ctx, open); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !open.KeepPageCache {
+		t.Fatalf("expected the page cache to be kept")
+	}
+
+	size := uint64(0)
+	for _, test := range []struct {
+		name string
+		op   func() error
+	}{
+		{"SetInodeAttributes", func() error {
+			return fs.SetInodeAttributes(ctx, &fuseops.SetInodeAttributesOp{Inode: id, Size: &size})
+		}},
+		{"MkDir", func() error {
+			return fs.MkDir(ctx, &fuseops.MkDirOp{Parent: fuseops.RootInodeID, Name: "dir"})
+		}},
+		{"MkNode", func() error {
+			return fs.MkNode(ctx, &fuseops.MkNodeOp{Parent: fuseops.RootInodeID, Name: "node"})
+		}},
+		{"CreateFile", func() error {
+			return fs.CreateFile(ctx, &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "b.txt"})
+		}},
+		{"CreateSymlink", func() error {
+			return fs.CreateSymlink(ctx, &fuseops.CreateSymlinkOp{Parent: fuseops.RootInodeID, Name: "link", Target: "a.txt"})
+		}},
+		{"CreateLink", func() error {
+			return fs.CreateLink(ctx, &fuseops.CreateLinkOp{Parent: fuseops.RootInodeID, Name: "link", Target: id})
+		}},
+		{"Rename", func() error {
+			return fs.Rename(ctx, &fuseops.RenameOp{OldParent: fuseops.RootInodeID, OldName: "a.txt", NewParent: fuseops.RootInodeID, NewName: "c.txt"})
+		}},
+		{"RmDir", func() error {
+			return fs.RmDir(ctx, &fuseops.RmDirOp{Parent: fuseops.RootInodeID, Name: "dir"})
+		}},
+		{"Unlink", func() error {
+			return fs.Unlink(ctx, &fuseops.UnlinkOp{Parent: fuseops.RootInodeID, Name: "a.txt"})
+		}},
+		{"WriteFile", func() error {
+			return fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: id, Handle: open.Handle, Data: []byte("b")})
+		}},
+		{"SetXattr", func() error {
+			return fs.SetXattr(ctx, &fuseops.SetXattrOp{Inode: id, Name: "user.a", Value: []byte("a")})
+		}},
+		{"RemoveXattr", func() error {
+			return fs.RemoveXattr(ctx, &fuseops.RemoveXattrOp{Inode: id, Name: "user.a"})
+		}},
+	} {
+		if err := test.op(); err != syscall.EROFS {
+			t.Fatalf("%s: expected EROFS, but got %v", test.name, err)
+		}
+	}
+	if b, _ := blobs.Get("a.txt"); string(b.Data) != "a" || len(blobs.Names()) != 1 {
+		t.Fatalf("expected the container to be left alone, but got %v", blobs.Names())
+	}
+}
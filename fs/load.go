@@ -0,0 +1,426 @@
+package fs
+
+import (
+	"context"
+	"crypto/md5"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/dedup"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// blobDelimiter separates the directories in a blob name.
+	blobDelimiter = "/"
+)
+
+// listDir populates a directory from the blobs under its prefix the first time
+// it's needed, and refreshes it once its entries have expired. Refreshing picks
+// up blobs added, changed or deleted by someone else; entries with changes of
+// our own are left alone. It's called with mu held, which is released while
+// listing.
+func (fs *lightningFS) listDir(ctx context.Context, id fuseops.InodeID, dir *iNode) error {
+	switch {
+	case dir.snapshots:
+		return fs.listSnapshots(ctx, id, dir)
+	case dir.snapshot != "":
+		// Populated along with its .snapshots directory.
+		return nil
+	case dir.trash:
+		return fs.listTrash(ctx, id, dir)
+	}
+	if dir.container == nil {
+		if dir.account == nil {
+			return nil
+		}
+		return fs.listContainers(ctx, id, dir)
+	}
+	if dir.listed && time.Since(dir.validated) < fs.entryTTL {
+		return nil
+	}
+
+	// As when polling, the listing is done without holding the lock.
+	c, prefix := dir.container, dirPrefix(dir)
+	var l *listing
+	err := fs.unlocked(func() (err error) {
+		l, err = listPrefix(ctx, c, prefix)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	return fs.applyListing(id, dir, l)
+}
+
+// dirPrefix returns the blob prefix of the blobs in a directory.
+func dirPrefix(dir *iNode) string {
+	if dir.name == "" {
+		return ""
+	}
+	return dir.name + blobDelimiter
+}
+
+// listing is the contents of a blob prefix, one level deep.
+type listing struct {
+	prefix   string
+	prefixes []azblob.BlobPrefix
+	items    []azblob.BlobItem
+}
+
+// listPrefix lists the blobs and blob prefixes directly under a prefix. It
+// doesn't touch any inodes, so it can be called without holding the lock.
+func listPrefix(ctx context.Context, c *container, prefix string) (*listing, error) {
+	l := &listing{prefix: prefix}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := c.url.ListBlobsHierarchySegment(ctx, marker, blobDelimiter, azblob.ListBlobsSegmentOptions{
+			Prefix:  prefix,
+			Details: azblob.BlobListingDetails{Metadata: true},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %q", prefix)
+		}
+		marker = resp.NextMarker
+		l.prefixes = append(l.prefixes, resp.Segment.BlobPrefixes...)
+		l.items = append(l.items, resp.Segment.BlobItems...)
+	}
+	return l, nil
+}
+
+// applyListing brings a directory's entries in line with a listing of its
+// prefix.
+func (fs *lightningFS) applyListing(id fuseops.InodeID, dir *iNode, l *listing) error {
+	seen := make(map[string]bool)
+	for _, p := range l.prefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(p.Name, l.prefix), blobDelimiter)
+		if fs.isChunksDir(dir, name) {
+			continue
+		}
+		seen[name] = true
+		if _, _, ok := dir.LookUpChild(name); !ok {
+			fs.addDir(id, dir, name)
+		}
+	}
+	for _, item := range l.items {
+		name := strings.TrimPrefix(item.Name, l.prefix)
+		seen[name] = true
+		childID, _, ok := dir.LookUpChild(name)
+		if !ok {
+			fs.addBlob(id, dir, name, item)
+			continue
+		}
+		if err := fs.refreshChild(childID, item); err != nil {
+			return err
+		}
+	}
+
+	if dir.listed {
+		for _, e := range dir.entries {
+			if e.Type == fuseutil.DT_Unknown || seen[e.Name] {
+				continue
+			}
+			if child := fs.inodes[e.Inode]; child != nil && fs.isStale(child, 0) {
+				dir.removeChild(e.Name)
+			}
+		}
+	}
+
+	dir.listed = true
+	dir.validated = time.Now()
+	return nil
+}
+
+// refreshChild updates a file from a fresh listing of its blob.
+func (fs *lightningFS) refreshChild(id fuseops.InodeID, item azblob.BlobItem) error {
+	child := fs.inodes[id]
+	if child == nil || !child.isFile() || !fs.isStale(child, 0) {
+		return nil
+	}
+	if child.etag != item.Properties.Etag {
+		return child.invalidate(item)
+	}
+	// Changing tiers doesn't change the ETag.
+	child.setTier(item.Properties.AccessTier, item.Properties.ArchiveStatus)
+	child.validated = time.Now()
+	return nil
+}
+
+// addDir adds a directory for a blob prefix to its parent.
+func (fs *lightningFS) addDir(parentID fuseops.InodeID, parent *iNode, name string) (fuseops.InodeID, *iNode) {
+	now := time.Now()
+	id, child := fs.allocateInode(fuseops.InodeAttributes{
+		Nlink:  1,
+		Mode:   0700 | os.ModeDir,
+		Atime:  now,
+		Mtime:  now,
+		Ctime:  now,
+		Crtime: now,
+		Uid:    fs.uid,
+		Gid:    fs.gid,
+	})
+	child.name = path.Join(parent.name, name)
+	child.parent = parentID
+	child.container = parent.container
+	child.exists = true
+	parent.addChild(id, name, fuseutil.DT_Directory)
+	return id, child
+}
+
+// addBlob adds a file for an existing blob to its parent. Its contents are
+// loaded when they're first needed.
+func (fs *lightningFS) addBlob(parentID fuseops.InodeID, parent *iNode, name string, item azblob.BlobItem) (fuseops.InodeID, *iNode) {
+	props := item.Properties
+	id, child := fs.allocateInode(fuseops.InodeAttributes{
+		Nlink:  1,
+		Mode:   0600,
+		Atime:  props.LastModified,
+		Mtime:  props.LastModified,
+		Ctime:  props.LastModified,
+		Crtime: props.LastModified,
+		Uid:    fs.uid,
+		Gid:    fs.gid,
+	})
+	child.name = path.Join(parent.name, name)
+	child.parent = parentID
+	child.container = parent.container
+	child.contents = newFileBuffer(fs.stager)
+	child.setBlob(item)
+	parent.addChild(id, name, fuseutil.DT_File)
+	return id, child
+}
+
+// setBlob records the properties of the blob backing a file whose contents
+// haven't been loaded yet.
+func (in *iNode) setBlob(item azblob.BlobItem) {
+	props := item.Properties
+	in.blobType = props.BlobType
+	in.setTier(props.AccessTier, props.ArchiveStatus)
+	in.etag = props.Etag
+	in.exists = true
+	in.loaded = false
+	in.dirty = false
+
+	if props.ContentLength != nil {
+		in.remoteSize = *props.ContentLength
+	}
+	in.attrs.Size = uint64(in.remoteSize)
+	if size, err := strconv.ParseInt(item.Metadata[sizeMetadataKey], 10, 64); err == nil {
+		in.attrs.Size = uint64(size)
+	}
+	// Blobs compressed in an unknown way are loaded, and fail, as a whole.
+	in.chunks, _ = parseChunks(item.Metadata)
+	in.chunk = nil
+	in.keyMetadata = nil
+	if _, ok := item.Metadata[keyMetadataKey]; ok {
+		in.keyMetadata = azblob.Metadata{
+			keyMetadataKey:   item.Metadata[keyMetadataKey],
+			keyIDMetadataKey: item.Metadata[keyIDMetadataKey],
+		}
+	}
+	in.attrs.Mtime = props.LastModified
+	in.validated = time.Now()
+}
+
+// isZero reports whether p is all zeros.
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// blobItemFromProperties converts the properties of a blob into the form
+// returned when listing blobs.
+func blobItemFromProperties(name string, resp *azblob.BlobGetPropertiesResponse) azblob.BlobItem {
+	contentLength := resp.ContentLength()
+	return azblob.BlobItem{
+		Name: name,
+		Properties: azblob.BlobProperties{
+			LastModified:  resp.LastModified(),
+			Etag:          resp.ETag(),
+			ContentLength: &contentLength,
+			BlobType:      resp.BlobType(),
+			AccessTier:    azblob.AccessTierType(resp.AccessTier()),
+			ArchiveStatus: azblob.ArchiveStatusType(resp.ArchiveStatus()),
+		},
+		Metadata: resp.NewMetadata(),
+	}
+}
+
+// checkLoadable returns an error for files whose blobs can't be read.
+func checkLoadable(in *iNode) error {
+	if in.deleted {
+		// Soft deleted blobs can't be read until they're restored.
+		return syscall.EACCES
+	}
+	return checkArchived(in)
+}
+
+// loadFile downloads the contents of a file the first time they're needed,
+// recording the ETag of the version downloaded. It's called with mu held,
+// which is released while downloading.
+func (fs *lightningFS) loadFile(ctx context.Context, in *iNode) error {
+	for !in.loaded {
+		if in.transfer != nil {
+			// Someone else is already loading it.
+			fs.waitTransfer(in)
+			continue
+		}
+		if err := checkLoadable(in); err != nil {
+			return err
+		}
+		if err := fs.downloadFile(ctx, in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadFile downloads the contents of a file into a new buffer, which
+// replaces its contents unless the file was invalidated in the meantime.
+func (fs *lightningFS) downloadFile(ctx context.Context, in *iNode) error {
+	done := fs.startTransfer(in)
+	defer done()
+
+	etag := in.etag
+	blobURL := in.container.url.NewBlobURL(in.name).WithSnapshot(in.snapshot)
+	contents := newFileBuffer(fs.stager)
+	var props *azblob.BlobGetPropertiesResponse
+	err := fs.unlocked(func() (err error) {
+		props, err = blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get properties of %s", in.name)
+		}
+		if props.NewMetadata()[dedup.ManifestMetadataKey] != "" {
+			return fs.downloadManifest(ctx, in, contents, blobURL, props)
+		}
+		return fs.downloadBlob(ctx, in, contents, blobURL, props)
+	})
+	if err != nil || in.etag != etag {
+		contents.Close()
+		return err
+	}
+
+	// The blob may have changed since it was listed, so its size is taken
+	// from the version downloaded.
+	size := props.ContentLength()
+	if v, err := strconv.ParseInt(props.NewMetadata()[sizeMetadataKey], 10, 64); err == nil {
+		size = v
+	}
+	in.etag = props.ETag()
+	in.remoteSize = contents.Size()
+	if size < in.remoteSize {
+		// The blob is padded, e.g. a page blob.
+		if err := contents.Truncate(size); err != nil {
+			contents.Close()
+			return err
+		}
+	}
+
+	// The old contents are empty, since the file wasn't loaded.
+	in.contents.Close()
+	in.contents = contents
+	in.attrs.Size = uint64(contents.Size())
+	in.attrs.Mtime = props.LastModified()
+	in.loaded = true
+	in.validated = time.Now()
+	return nil
+}
+
+// downloadBlob reads the contents of a file from its blob into contents,
+// decrypting or decompressing them if need be.
+func (fs *lightningFS) downloadBlob(ctx context.Context, in *iNode, contents *fileBuffer, blobURL azblob.BlobURL, props *azblob.BlobGetPropertiesResponse) error {
+	metadata := props.NewMetadata()
+	aead, err := fs.dataKey(in, metadata)
+	if err != nil {
+		return err
+	}
+	chunks, err := parseChunks(metadata)
+	if err != nil {
+		log.Printf("failed to decompress %s: %v", in.name, err)
+		return fuse.EIO
+	}
+	rangeSize := int64(blockSize)
+	if aead != nil {
+		rangeSize = encryptedRangeSize
+	}
+
+	// Download in ranges small enough for the service to checksum, making sure
+	// they all come from the same version of the blob.
+	ac := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()},
+	}
+	whole := md5.New()
+	buf := make([]byte, rangeSize)
+	var plaintext []byte
+	for off, plainOff, size, n := int64(0), int64(0), props.ContentLength(), 0; off < size; n++ {
+		// Compressed blocks are read whole.
+		length := min64(rangeSize, size-off)
+		if chunks != nil {
+			if n >= len(chunks) {
+				log.Printf("failed to decompress %s: it's longer than its chunk index", in.name)
+				return fuse.EIO
+			}
+			length = chunks[n]
+		}
+		if int64(len(buf)) < length {
+			buf = make([]byte, length)
+		}
+
+		chunk := buf[:length]
+		if err := downloadRange(ctx, blobURL, in.name, off, chunk, ac); err != nil {
+			return err
+		}
+		whole.Write(chunk)
+
+		data := chunk
+		switch {
+		case chunks != nil:
+			if plaintext, err = decompressChunk(plaintext[:0], chunk); err != nil {
+				log.Printf("failed to decompress %s: %v", in.name, err)
+				return fuse.EIO
+			}
+			data = plaintext
+		case aead != nil:
+			plaintext, err = openChunks(aead, plaintext[:0], chunk, off/sealedChunkSize)
+			if err != nil {
+				log.Printf("failed to decrypt %s: %v", in.name, err)
+				return fuse.EIO
+			}
+			data = plaintext
+		}
+
+		// Leave runs of zeros as holes.
+		if isZero(data) {
+			err = contents.Truncate(plainOff + int64(len(data)))
+		} else {
+			_, err = contents.WriteAt(data, plainOff)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to buffer %s", in.name)
+		}
+		off += int64(len(chunk))
+		plainOff += int64(len(data))
+	}
+	if err := verifyMD5(in.name, 0, props.ContentMD5(), whole.Sum(nil)); err != nil {
+		return err
+	}
+	// Dropping whole chunks from the end of an encrypted blob would otherwise
+	// go unnoticed.
+	if (aead != nil || chunks != nil) && metadata[sizeMetadataKey] != strconv.FormatInt(contents.Size(), 10) {
+		log.Printf("failed to load %s: expected %s bytes but got %d", in.name, metadata[sizeMetadataKey], contents.Size())
+		return fuse.EIO
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestLoadFileChangedSinceListing(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+
+	ctx := context.Background()
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("one")})
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.txt"}
+	if err := server.fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := lookUp.Entry.Child
+
+	// The contents downloaded are kept whole, even though the blob was
+	// shorter when it was listed.
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("one two three")})
+	read := &fuseops.ReadFileOp{Inode: id, Dst: make([]byte, 64)}
+	if err := server.fs.ReadFile(ctx, read); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got := string(read.Dst[:read.BytesRead]); got != "one two three" {
+		t.Fatalf("expected %q but got %q", "one two three", got)
+	}
+
+	attrs := &fuseops.GetInodeAttributesOp{Inode: id}
+	if err := server.fs.GetInodeAttributes(ctx, attrs); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if attrs.Attributes.Size != 13 {
+		t.Fatalf("expected a size of 13, but got %d", attrs.Attributes.Size)
+	}
+
+	write := &fuseops.WriteFileOp{Inode: id, Offset: 3, Data: []byte("!")}
+	if err := server.fs.WriteFile(ctx, write); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if b, _ := blobs.Get("a.txt"); string(b.Data) != "one!two three" {
+		t.Fatalf("expected %q but got %q", "one!two three", b.Data)
+	}
+}
@@ -0,0 +1,187 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/errors"
+)
+
+const (
+	// sizeMetadataKey records the logical size of a file whose blob is larger,
+	// e.g. a page blob which is rounded up to a whole number of pages.
+	sizeMetadataKey = "lightningsize"
+)
+
+// pageRange is a page aligned range [from, to) of a page blob.
+type pageRange struct {
+	from, to int64
+}
+
+func alignDown(off int64) int64 {
+	return off &^ (azblob.PageBlobPageBytes - 1)
+}
+
+func alignUp(off int64) int64 {
+	return alignDown(off + azblob.PageBlobPageBytes - 1)
+}
+
+// writePages uploads the pages covering [off, end) of a page blob file. Pages
+// which are only partially covered are filled in from the rest of the file's
+// contents. If the upload fails, the pages are left for the next sync. Writes
+// which reach the end of the file record its new size once they're uploaded.
+func (fs *lightningFS) writePages(ctx context.Context, in *iNode, off int64, end int64) error {
+	in.unsyncedPages = append(in.unsyncedPages, pageRange{alignDown(off), alignUp(end)})
+	if !in.exists {
+		// Creating the blob uploads everything.
+		return fs.syncPageBlob(ctx, in)
+	}
+	if err := fs.resizePageBlob(ctx, in); err != nil {
+		return err
+	}
+	if err := fs.uploadUnsyncedPages(ctx, in); err != nil {
+		return err
+	}
+	if end < in.contents.Size() {
+		return nil
+	}
+	return fs.setPageBlobSize(ctx, in)
+}
+
+// uploadUnsyncedPages uploads the ranges of a page blob file which haven't
+// been yet, keeping those which fail for the next attempt. The blob must
+// already be the right size.
+func (fs *lightningFS) uploadUnsyncedPages(ctx context.Context, in *iNode) error {
+	capacity := alignUp(in.contents.Size())
+	for len(in.unsyncedPages) > 0 {
+		// The file may have shrunk since the range was written.
+		r := in.unsyncedPages[0]
+		if to := min64(r.to, capacity); r.from < to {
+			if err := fs.uploadPages(ctx, in, r.from, to); err != nil {
+				return err
+			}
+		}
+		in.unsyncedPages = in.unsyncedPages[1:]
+	}
+	return nil
+}
+
+// uploadPages uploads [from, to) of a page blob file, which must be page
+// aligned.
+func (fs *lightningFS) uploadPages(ctx context.Context, in *iNode, from int64, to int64) error {
+	pageBlobURL := in.container.url.NewPageBlobURL(in.name)
+	for from < to {
+		n := min64(to-from, azblob.PageBlobMaxUploadPagesBytes)
+		buf := make([]byte, n)
+		if _, err := in.contents.ReadAt(buf, from); err != nil && err != io.EOF {
+			return errors.Wrapf(err, "failed to read %s", in.name)
+		}
+		bac := fs.accessConditions(in)
+		ac := azblob.PageBlobAccessConditions{
+			ModifiedAccessConditions: bac.ModifiedAccessConditions,
+			LeaseAccessConditions:    bac.LeaseAccessConditions,
+		}
+		resp, err := pageBlobURL.UploadPages(ctx, from, bytes.NewReader(buf), ac, md5Sum(buf))
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload pages at %d for %s", from, in.name)
+		}
+		in.etag = resp.ETag()
+		from += n
+	}
+	return nil
+}
+
+// resizePageBlob resizes a page blob to fit the file's contents.
+func (fs *lightningFS) resizePageBlob(ctx context.Context, in *iNode) error {
+	capacity := alignUp(in.contents.Size())
+	if capacity == in.remoteSize {
+		return nil
+	}
+
+	pageBlobURL := in.container.url.NewPageBlobURL(in.name)
+	resp, err := pageBlobURL.Resize(ctx, capacity, fs.accessConditions(in))
+	if err != nil {
+		return errors.Wrapf(err, "failed to resize %s", in.name)
+	}
+	in.etag = resp.ETag()
+	in.remoteSize = capacity
+	return nil
+}
+
+// truncatePageBlob resizes a file's page blob after the file is truncated from
+// oldSize. Shrinking within a page leaves the page in place, so the part of it
+// past the new size is zeroed; otherwise the old data would reappear if the
+// file were extended again. The new size is recorded straight away.
+func (fs *lightningFS) truncatePageBlob(ctx context.Context, in *iNode, oldSize int64) error {
+	if err := fs.resizePageBlob(ctx, in); err != nil {
+		return err
+	}
+	size := in.contents.Size()
+	if from := alignDown(size); size < oldSize && from != size {
+		// Reading past the end of the contents yields zeros.
+		in.unsyncedPages = append(in.unsyncedPages, pageRange{from, alignUp(size)})
+	}
+	if err := fs.uploadUnsyncedPages(ctx, in); err != nil {
+		return err
+	}
+	return fs.setPageBlobSize(ctx, in)
+}
+
+// setPageBlobSize records the logical size of a file's page blob.
+func (fs *lightningFS) setPageBlobSize(ctx context.Context, in *iNode) error {
+	pageBlobURL := in.container.url.NewPageBlobURL(in.name)
+	resp, err := pageBlobURL.SetMetadata(ctx, pageBlobMetadata(in), fs.accessConditions(in))
+	if err != nil {
+		return errors.Wrapf(err, "failed to set metadata for %s", in.name)
+	}
+	in.etag = resp.ETag()
+	return nil
+}
+
+func pageBlobMetadata(in *iNode) azblob.Metadata {
+	return azblob.Metadata{sizeMetadataKey: strconv.FormatInt(in.contents.Size(), 10)}
+}
+
+// syncPageBlob creates a file's page blob if it doesn't exist yet, uploads any
+// pages which haven't been, and records its logical size. Page blobs are
+// sparse, so only the file's data extents are uploaded when creating one.
+func (fs *lightningFS) syncPageBlob(ctx context.Context, in *iNode) error {
+	if in.exists {
+		if err := fs.resizePageBlob(ctx, in); err != nil {
+			return err
+		}
+		if err := fs.uploadUnsyncedPages(ctx, in); err != nil {
+			return err
+		}
+		if err := fs.setPageBlobSize(ctx, in); err != nil {
+			return err
+		}
+		in.dirty = false
+		return nil
+	}
+
+	pageBlobURL := in.container.url.NewPageBlobURL(in.name)
+	capacity := alignUp(in.contents.Size())
+	resp, err := pageBlobURL.Create(ctx, capacity, 0, azblob.BlobHTTPHeaders{}, pageBlobMetadata(in), fs.accessConditions(in))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", in.name)
+	}
+	in.etag = resp.ETag()
+	in.exists = true
+	in.remoteSize = capacity
+
+	for off, ok := in.contents.SeekData(0); ok; off, ok = in.contents.SeekData(off) {
+		end := in.contents.SeekHole(off)
+		if err := fs.uploadPages(ctx, in, alignDown(off), alignUp(end)); err != nil {
+			return err
+		}
+		off = end
+	}
+
+	in.unsyncedPages = nil
+	in.dirty = false
+	return nil
+}
@@ -0,0 +1,136 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func storePageBlobs(c *config.Config) {
+	c.PageBlobPatterns = []string{"*.vhd"}
+}
+
+func TestPageBlobs(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		writes   []*fuseops.WriteFileOp
+		truncate *uint64
+		expected []byte
+	}{
+		{
+			name:     "aligned",
+			writes:   []*fuseops.WriteFileOp{{Data: bytes.Repeat([]byte("a"), 1024)}},
+			expected: bytes.Repeat([]byte("a"), 1024),
+		},
+		{
+			name: "unaligned",
+			writes: []*fuseops.WriteFileOp{
+				{Data: []byte("hello")},
+				{Offset: 510, Data: []byte("world")},
+			},
+			expected: append(append([]byte("hello"), make([]byte, 505)...), "world"...),
+		},
+		{
+			name:     "shrink",
+			writes:   []*fuseops.WriteFileOp{{Data: []byte("hello world")}},
+			truncate: uint64Ptr(5),
+			expected: []byte("hello"),
+		},
+		{
+			name:     "extend",
+			writes:   []*fuseops.WriteFileOp{{Data: []byte("hello")}},
+			truncate: uint64Ptr(600),
+			expected: append([]byte("hello"), make([]byte, 595)...),
+		},
+	} {
+		server, blobs, cleanup := newTestFS(t, storePageBlobs)
+
+		ctx := context.Background()
+		name := test.name + ".vhd"
+		create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: name, Mode: 0600}
+		if err := server.fs.CreateFile(ctx, create); err != nil {
+			t.Fatalf("%s: unexpected err: %v", test.name, err)
+		}
+		for _, write := range test.writes {
+			write.Inode, write.Handle = create.Entry.Child, create.Handle
+			if err := server.fs.WriteFile(ctx, write); err != nil {
+				t.Fatalf("%s: unexpected err: %v", test.name, err)
+			}
+		}
+		if test.truncate != nil {
+			op := &fuseops.SetInodeAttributesOp{Inode: create.Entry.Child, Size: test.truncate}
+			if err := server.fs.SetInodeAttributes(ctx, op); err != nil {
+				t.Fatalf("%s: unexpected err: %v", test.name, err)
+			}
+		}
+
+		// Writes and truncates go straight through to the blob, which holds
+		// whole pages.
+		b, _ := blobs.Get(name)
+		padded := make([]byte, alignUp(int64(len(test.expected))))
+		copy(padded, test.expected)
+		if b.Type != azblob.BlobPageBlob || !bytes.Equal(b.Data, padded) {
+			t.Fatalf("%s: expected a page blob holding %q but got a %s holding %q", test.name, padded, b.Type, b.Data)
+		}
+
+		// The logical size is read back from the blob's metadata.
+		data, err := readFile(t, blobs, storePageBlobs, name)
+		if err != nil {
+			t.Fatalf("%s: unexpected err: %v", test.name, err)
+		}
+		if !bytes.Equal(data, test.expected) {
+			t.Fatalf("%s: expected %q but got %q", test.name, test.expected, data)
+		}
+		cleanup()
+	}
+}
+
+func TestPageBlobFailedWrite(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, storePageBlobs)
+	defer cleanup()
+
+	ctx := context.Background()
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "disk.vhd", Mode: 0600}
+	if err := server.fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := create.Entry.Child
+	write := &fuseops.WriteFileOp{Inode: id, Handle: create.Handle, Data: []byte("hello")}
+	if err := server.fs.WriteFile(ctx, write); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// The pages which fail to upload are uploaded again by the next sync.
+	blobs.Hook = func(r *http.Request) int {
+		if r.URL.Query().Get("comp") == "page" {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+	write = &fuseops.WriteFileOp{Inode: id, Handle: create.Handle, Offset: 1000, Data: []byte("world")}
+	if err := server.fs.WriteFile(ctx, write); err == nil {
+		t.Fatalf("expected the write to fail")
+	}
+	blobs.Hook = nil
+	if err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := append(append([]byte("hello"), make([]byte, 995)...), "world"...)
+	data, err := readFile(t, blobs, storePageBlobs, "disk.vhd")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected %q but got %q", expected, data)
+	}
+}
+
+func uint64Ptr(n uint64) *uint64 {
+	return &n
+}
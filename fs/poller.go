@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// poll periodically refreshes the directories which have been listed until
+// stop is closed, so that long running mounts pick up changes made by others.
+// done is closed once it has exited.
+//
+// The FUSE library doesn't expose the kernel's notify mechanism, so the kernel
+// only sees changes once its cached attributes and entries expire. Polling is
+// best paired with short TTLs; lookups then don't need to go to the container
+// because the poller keeps the inodes validated.
+func (fs *lightningFS) poll(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	// Abandon a poll in progress once stopped.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fs.pollOnce(ctx, interval, stop)
+		}
+	}
+}
+
+// startPolling replaces the background poller with one polling at the given
+// interval, or stops it if the interval is zero. It's called with mu held,
+// after which the old poller makes no more changes. The returned channel is
+// closed once the old poller has exited; it mustn't be waited on with mu held.
+func (fs *lightningFS) startPolling(interval time.Duration) <-chan struct{} {
+	stopped := fs.pollDone
+	if stopped == nil {
+		closed := make(chan struct{})
+		close(closed)
+		stopped = closed
+	}
+	if fs.stopPolling != nil {
+		close(fs.stopPolling)
+		fs.stopPolling, fs.pollDone = nil, nil
+	}
+	if interval > 0 {
+		fs.stopPolling, fs.pollDone = make(chan struct{}), make(chan struct{})
+		go fs.poll(interval, fs.stopPolling, fs.pollDone)
+	}
+	return stopped
+}
+
+// pollOnce refreshes the directories of mounted containers which have been
+// listed, skipping any refreshed within the last interval, e.g. by a lookup.
+// Only listed directories are polled, so a tick costs a listing of what the
+// mount has looked at rather than of whole containers. The listing is done
+// without holding the lock.
+func (fs *lightningFS) pollOnce(ctx context.Context, interval time.Duration, stop <-chan struct{}) {
+	type polled struct {
+		id     fuseops.InodeID
+		dir    *iNode
+		prefix string
+	}
+
+	fs.mu.RLock()
+	mounted := make(map[*container]bool)
+	for _, id := range fs.containerRoots {
+		mounted[fs.inodes[id].container] = true
+	}
+	var dirs []polled
+	for id, in := range fs.inodes {
+		if in == nil || !in.isDir() || !in.listed || !mounted[in.container] || in.isImmutable() ||
+			time.Since(in.validated) < interval {
+			continue
+		}
+		dirs = append(dirs, polled{fuseops.InodeID(id), in, dirPrefix(in)})
+	}
+	fs.mu.RUnlock()
+
+	for _, d := range dirs {
+		l, err := listPrefix(ctx, d.dir.container, d.prefix)
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Printf("failed to poll for changes: %v", err)
+			continue
+		}
+
+		fs.mu.Lock()
+		select {
+		case <-stop:
+			fs.mu.Unlock()
+			return
+		default:
+		}
+		err = fs.applyListing(d.id, d.dir, l)
+		fs.mu.Unlock()
+		if err != nil {
+			log.Printf("failed to poll for changes: %v", err)
+		}
+	}
+}
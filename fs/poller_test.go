@@ -0,0 +1,108 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestPollOnce(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	fs := server.fs
+	root := fs.inodes[fuseops.RootInodeID]
+
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("a")})
+	blobs.Put(blobtest.Blob{Name: "dir/b.txt", Data: []byte("b")})
+	fs.mu.Lock()
+	err := fs.listDir(context.Background(), fuseops.RootInodeID, root)
+	fs.mu.Unlock()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// Only listed directories are polled, so dir stays unlisted.
+	blobs.Put(blobtest.Blob{Name: "c.txt", Data: []byte("c")})
+	blobs.Put(blobtest.Blob{Name: "dir/d.txt", Data: []byte("d")})
+	fs.pollOnce(context.Background(), 0, make(chan struct{}))
+	if lookUp(fs, root, "c.txt") == nil {
+		t.Fatal("expected c.txt to be picked up")
+	}
+	if dir := lookUp(fs, root, "dir"); dir == nil || dir.listed {
+		t.Fatal("expected dir to be added, but not listed")
+	}
+
+	// Directories refreshed within the interval are skipped.
+	blobs.Put(blobtest.Blob{Name: "e.txt", Data: []byte("e")})
+	fs.pollOnce(context.Background(), time.Hour, make(chan struct{}))
+	if lookUp(fs, root, "e.txt") != nil {
+		t.Fatal("expected the recently refreshed root to be skipped")
+	}
+
+	// A stopped poller makes no changes.
+	stop := make(chan struct{})
+	close(stop)
+	fs.pollOnce(context.Background(), 0, stop)
+	if lookUp(fs, root, "e.txt") != nil {
+		t.Fatal("expected a stopped poll to make no changes")
+	}
+}
+
+func TestStartPolling(t *testing.T) {
+	server, _, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	fs := server.fs
+
+	fs.mu.Lock()
+	fs.startPolling(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	stopped := fs.startPolling(0)
+	fs.mu.Unlock()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the poller to exit")
+	}
+}
+
+func TestPollConcurrently(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, func(c *config.Config) { c.Prefix = "data" })
+	defer cleanup()
+	fs := server.fs
+
+	for _, name := range []string{"data/a.txt", "data/dir/b.txt", "other/c.txt"} {
+		blobs.Put(blobtest.Blob{Name: name, Data: []byte(name)})
+	}
+	op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "dir"}
+	if err := fs.LookUpInode(context.Background(), op); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// Polls read the inodes while lookups allocate more; run with -race.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			fs.pollOnce(context.Background(), 0, make(chan struct{}))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		blobs.Put(blobtest.Blob{Name: fmt.Sprintf("data/dir/%d.txt", i)})
+		op := &fuseops.LookUpInodeOp{Parent: op.Entry.Child, Name: fmt.Sprintf("%d.txt", i)}
+		if err := fs.LookUpInode(context.Background(), op); err != nil && err != fuse.ENOENT {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	<-done
+
+	if lookUp(fs, fs.inodes[fuseops.RootInodeID], "other") != nil {
+		t.Fatal("expected blobs outside the prefix to be hidden")
+	}
+}
@@ -0,0 +1,130 @@
+package fs
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/defaults"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+// Server serves a lightningfs over FUSE, and can reconfigure it while it's
+// mounted.
+type Server struct {
+	fuse.Server
+	fs *lightningFS
+}
+
+// Reload applies a new config to the mounted file system. Credentials are
+// swapped in place, and the memory budget, TTLs, poll interval, conflict
+// policy and default tier are updated. Changes to anything else are logged
+// and only take effect once it's remounted.
+func (s *Server) Reload(cfg *config.Config) error {
+	fs := s.fs
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	conflictPolicy, err := parseConflictPolicy(cfg.ConflictPolicy)
+	if err != nil {
+		return err
+	}
+	defaultTier, err := parseTier(cfg.DefaultTier)
+	if err != nil {
+		return err
+	}
+	memoryBudget := cfg.MemoryBudget
+	if memoryBudget <= 0 {
+		memoryBudget = defaults.MemoryBudget
+	}
+
+	mounts := cfg.Mounts
+	if len(mounts) == 0 {
+		mounts = []config.Mount{{Credentials: cfg.Credentials}}
+	}
+	// Every mount's credentials are checked before any are swapped, so that
+	// a bad one doesn't leave the others half reloaded.
+	var swaps []func()
+	if len(mounts) != len(fs.credentials) {
+		log.Printf("reload: the number of mounts changed; remount to apply")
+	} else {
+		for i, m := range mounts {
+			swap, err := fs.credentials[i].Prepare(m.Credentials)
+			if err != nil {
+				return errors.Wrapf(err, "failed to reload credentials of mount %d", i)
+			}
+			swaps = append(swaps, swap)
+		}
+	}
+	for _, swap := range swaps {
+		swap()
+	}
+	if len(swaps) > 0 {
+		log.Printf("reload: credentials reloaded")
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	old := fs.config
+	attributeTTL, entryTTL := ttls(cfg)
+	logChange("memoryBudget", fs.stager.setBudget(memoryBudget), memoryBudget)
+	logChange("attributeTTL", fs.attributeTTL, attributeTTL)
+	logChange("entryTTL", fs.entryTTL, entryTTL)
+	logChange("negativeTTL", fs.negativeTTL, cfg.NegativeTTL)
+	logChange("pollInterval", old.PollInterval, cfg.PollInterval)
+	logChange("conflictPolicy", fs.conflictPolicy, conflictPolicy)
+	logChange("defaultTier", fs.defaultTier, defaultTier)
+
+	fs.attributeTTL, fs.entryTTL, fs.negativeTTL = attributeTTL, entryTTL, cfg.NegativeTTL
+	if cfg.PollInterval != old.PollInterval {
+		fs.startPolling(cfg.PollInterval)
+	}
+	fs.conflictPolicy = conflictPolicy
+	fs.defaultTier = defaultTier
+
+	for _, setting := range []struct {
+		key      string
+		old, new interface{}
+	}{
+		{"cachePath", old.CachePath, cfg.CachePath},
+		{"stagingPath", old.StagingPath, cfg.StagingPath},
+		{"containerName", old.ContainerName, cfg.ContainerName},
+		{"prefix", old.Prefix, cfg.Prefix},
+		{"mounts", mountLayout(old.Mounts), mountLayout(cfg.Mounts)},
+		{"pageBlobPatterns", old.PageBlobPatterns, cfg.PageBlobPatterns},
+		{"appendBlobPatterns", old.AppendBlobPatterns, cfg.AppendBlobPatterns},
+		{"compressPatterns", old.CompressPatterns, cfg.CompressPatterns},
+		{"leaseWrites", old.LeaseWrites, cfg.LeaseWrites},
+		{"encryptionKeyFile", old.EncryptionKeyFile, cfg.EncryptionKeyFile},
+		{"dedup", old.Dedup, cfg.Dedup},
+		{"readOnly", old.ReadOnly, cfg.ReadOnly},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			log.Printf("reload: %s changed; remount to apply", setting.key)
+		}
+	}
+
+	fs.config = cfg
+	return nil
+}
+
+// logChange logs a setting which is changing.
+func logChange(key string, old interface{}, new interface{}) {
+	if old != new {
+		log.Printf("reload: %s changed from %v to %v", key, old, new)
+	}
+}
+
+// mountLayout returns the parts of mounts which can't be changed by reloading.
+func mountLayout(mounts []config.Mount) []config.Mount {
+	layout := make([]config.Mount, len(mounts))
+	for i, m := range mounts {
+		layout[i] = config.Mount{Name: m.Name, ContainerName: m.ContainerName, Prefix: m.Prefix}
+		layout[i].AzureAccountName = m.AzureAccountName
+		layout[i].BlobEndpoint = m.BlobEndpoint
+	}
+	return layout
+}
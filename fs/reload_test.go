@@ -0,0 +1,100 @@
+package fs
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestReload(t *testing.T) {
+	server, _, cleanup := newTestFS(t, nil)
+	defer cleanup()
+
+	for _, test := range []struct {
+		change      func(c *config.Config)
+		shouldError bool
+	}{
+		{func(c *config.Config) { c.AzureAccountKey = "bmV3a2V5" }, false},
+		{func(c *config.Config) { c.MemoryBudget = 1 << 20; c.AttributeTTL = time.Second }, false},
+		{func(c *config.Config) { c.ConflictPolicy = config.ConflictRename }, false},
+		{func(c *config.Config) { c.ConflictPolicy = "merge" }, true},
+		{func(c *config.Config) { c.AzureAccountName = "other" }, true},
+	} {
+		next := *server.fs.config
+		test.change(&next)
+		err := server.Reload(&next)
+		if err != nil && test.shouldError {
+			continue
+		} else if err != nil && !test.shouldError {
+			t.Fatalf("unexpected err: %v", err)
+		} else if err == nil && test.shouldError {
+			t.Fatal("expected test to error, but it didn't")
+		}
+	}
+
+	fs := server.fs
+	if fs.stager.budget != 1<<20 || fs.attributeTTL != time.Second || fs.conflictPolicy != config.ConflictRename {
+		t.Fatalf("expected the changes to be applied: budget %d, attribute TTL %v, conflict policy %s", fs.stager.budget, fs.attributeTTL, fs.conflictPolicy)
+	}
+}
+
+func TestReloadMounts(t *testing.T) {
+	blobs := blobtest.NewServer()
+	defer blobs.Close()
+	var sig string
+	blobs.Hook = func(r *http.Request) int {
+		sig = r.URL.Query().Get("sig")
+		return 0
+	}
+
+	mount := func(name string, sas string) config.Mount {
+		return config.Mount{
+			Name:          name,
+			Credentials:   config.Credentials{AzureAccountName: name, SASToken: sas, BlobEndpoint: blobs.URL + "/account"},
+			ContainerName: "container",
+		}
+	}
+	server, _, cleanup := newTestFS(t, func(c *config.Config) {
+		c.ContainerName = ""
+		c.Mounts = []config.Mount{mount("a", "sv=1&sig=old"), mount("b", "sv=1&sig=old")}
+	})
+	defer cleanup()
+
+	// list returns the signature a listing of the first mount is sent with.
+	list := func() string {
+		id, _, _ := server.fs.inodes[fuseops.RootInodeID].LookUpChild("a")
+		server.fs.inodes[id].listed = false
+		op := &fuseops.ReadDirOp{Inode: id, Dst: make([]byte, 1024)}
+		if err := server.fs.ReadDir(context.Background(), op); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		return sig
+	}
+	if actual := list(); actual != "old" {
+		t.Fatalf("expected the old signature, but got %q", actual)
+	}
+
+	// The second mount can't move, so neither mount's credentials change.
+	next := *server.fs.config
+	next.Mounts = []config.Mount{mount("a", "sv=1&sig=new"), mount("b", "sv=1&sig=new")}
+	next.Mounts[1].BlobEndpoint = "http://127.0.0.1:1/account"
+	if err := server.Reload(&next); err == nil {
+		t.Fatal("expected moving a mount to error, but it didn't")
+	}
+	if actual := list(); actual != "old" {
+		t.Fatalf("expected the old signature to be kept, but got %q", actual)
+	}
+
+	next.Mounts[1].BlobEndpoint = blobs.URL + "/account"
+	if err := server.Reload(&next); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if actual := list(); actual != "new" {
+		t.Fatalf("expected the new signature, but got %q", actual)
+	}
+}
@@ -0,0 +1,103 @@
+package fs
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/internal/storage"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/pkg/errors"
+)
+
+// attributesExpiration returns when the kernel should stop caching attributes
+// handed out now.
+func (fs *lightningFS) attributesExpiration() time.Time {
+	return time.Now().Add(fs.attributeTTL)
+}
+
+// entryExpiration returns when the kernel should stop caching directory
+// entries handed out now.
+func (fs *lightningFS) entryExpiration() time.Time {
+	return time.Now().Add(fs.entryTTL)
+}
+
+// lookUpMissing answers a lookup of a child which doesn't exist. If negative
+// caching is enabled, the kernel is told to remember that it doesn't exist.
+func (fs *lightningFS) lookUpMissing(op *fuseops.LookUpInodeOp) error {
+	if fs.negativeTTL <= 0 {
+		return fuse.ENOENT
+	}
+
+	// An entry with a zero inode ID is a negative entry.
+	op.Entry = fuseops.ChildInodeEntry{
+		EntryExpiration: time.Now().Add(fs.negativeTTL),
+	}
+	return nil
+}
+
+// durationOrDefault returns d, or def if d isn't set.
+func durationOrDefault(d time.Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// isNotFound reports whether err was caused by a blob not existing.
+func isNotFound(err error) bool {
+	serr, ok := storage.AsError(err)
+	if !ok {
+		return false
+	}
+	return serr.ServiceCode() == azblob.ServiceCodeBlobNotFound ||
+		serr.Response().StatusCode == http.StatusNotFound
+}
+
+// isStale reports whether an inode backed by a blob should be checked for
+// changes made by someone else. Inodes with changes of our own aren't, and
+// nor are snapshots or deleted blobs, which can't change.
+func (fs *lightningFS) isStale(in *iNode, ttl time.Duration) bool {
+	return in.exists && !in.dirty && in.lease == nil && in.snapshot == "" && !in.deleted &&
+		time.Since(in.validated) >= ttl
+}
+
+// revalidate checks a file against its blob once its attributes have expired,
+// discarding any cached contents if the blob was changed by someone else.
+// found is false if the blob has been deleted.
+func (fs *lightningFS) revalidate(ctx context.Context, in *iNode) (found bool, err error) {
+	if !in.isFile() || !fs.isStale(in, fs.attributeTTL) {
+		return true, nil
+	}
+
+	props, err := in.container.url.NewBlobURL(in.name).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get properties of %s", in.name)
+	}
+
+	item := blobItemFromProperties(in.name, props)
+	if props.ETag() != in.etag {
+		if err = in.invalidate(item); err != nil {
+			return false, err
+		}
+	}
+	// Changing tiers doesn't change the ETag.
+	in.setTier(item.Properties.AccessTier, item.Properties.ArchiveStatus)
+	in.validated = time.Now()
+	return true, nil
+}
+
+// invalidate discards the cached contents of a file whose blob has changed and
+// records the blob's new properties.
+func (in *iNode) invalidate(item azblob.BlobItem) error {
+	if err := in.contents.Truncate(0); err != nil {
+		return err
+	}
+	in.setBlob(item)
+	return nil
+}
@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestRevalidate(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, func(c *config.Config) { c.AttributeTTL = time.Nanosecond })
+	defer cleanup()
+
+	ctx := context.Background()
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("one")})
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.txt"}
+	if err := server.fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := lookUp.Entry.Child
+
+	// Changes made by someone else are picked up once attributes expire.
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("three")})
+	attrs := &fuseops.GetInodeAttributesOp{Inode: id}
+	if err := server.fs.GetInodeAttributes(ctx, attrs); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if attrs.Attributes.Size != 5 {
+		t.Fatalf("expected the new size of 5, but got %d", attrs.Attributes.Size)
+	}
+
+	if _, err := blobs.ContainerURL().NewBlobURL("a.txt").Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := server.fs.GetInodeAttributes(ctx, attrs); err != fuse.ENOENT {
+		t.Fatalf("expected ENOENT once the blob is deleted, but got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// rejectsWrites reports whether changes are rejected, either because the file
+// system is read-only or because it's shutting down. Anything which changes a
+// file must check it while holding mu, so that nothing changes once Shutdown
+// has flushed.
+func (fs *lightningFS) rejectsWrites() bool {
+	return fs.readOnly || atomic.LoadInt32(&fs.draining) != 0
+}
+
+// Shutdown stops the file system accepting changes, uploads every file which
+// hasn't been yet and releases any leases. It returns an error if any file
+// couldn't be uploaded within the configured shutdown timeout, or before ctx
+// is done. The file system can still be read until it's unmounted.
+func (s *Server) Shutdown(ctx context.Context) error {
+	fs := s.fs
+	atomic.StoreInt32(&fs.draining, 1)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if timeout := fs.config.ShutdownTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	fs.startPolling(0)
+
+	var failed int
+	for _, inode := range fs.inodes {
+		if inode == nil || !inode.isFile() {
+			continue
+		}
+		if err := fs.syncFile(ctx, inode); err != nil {
+			log.Printf("failed to flush %s: %v", inode.name, err)
+			failed++
+		}
+		if err := fs.releaseLease(ctx, inode); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files couldn't be flushed", failed)
+	}
+	return nil
+}
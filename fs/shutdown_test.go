@@ -0,0 +1,108 @@
+package fs
+
+import (
+	"context"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/config"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// createDirty creates a file which has been written to but not uploaded.
+func createDirty(t *testing.T, server *Server, name string) fuseops.InodeID {
+	ctx := context.Background()
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: name, Mode: 0600}
+	if err := server.fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	write := &fuseops.WriteFileOp{Inode: create.Entry.Child, Handle: create.Handle, Data: []byte(name)}
+	if err := server.fs.WriteFile(ctx, write); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	return create.Entry.Child
+}
+
+func TestShutdown(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	id := createDirty(t, server, "a.txt")
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if b, ok := blobs.Get("a.txt"); !ok || string(b.Data) != "a.txt" {
+		t.Fatalf("expected a.txt to be flushed, but got %q", b.Data)
+	}
+
+	write := &fuseops.WriteFileOp{Inode: id, Data: []byte("more")}
+	if err := server.fs.WriteFile(context.Background(), write); err != syscall.EROFS {
+		t.Fatalf("expected EROFS after shutting down, but got %v", err)
+	}
+}
+
+func TestShutdownFailures(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	createDirty(t, server, "a.txt")
+	createDirty(t, server, "b.txt")
+
+	blobs.Hook = func(r *http.Request) int {
+		if r.Method == http.MethodPut {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+	err := server.Shutdown(context.Background())
+	if err == nil || err.Error() != "2 files couldn't be flushed" {
+		t.Fatalf("expected 2 files to fail, but got %v", err)
+	}
+}
+
+func TestShutdownConcurrently(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	id := createDirty(t, server, "a.txt")
+
+	// Every write which succeeds must be flushed, however it's ordered with
+	// the shutdown.
+	written := make(chan int64)
+	go func() {
+		offset := int64(len("a.txt"))
+		for {
+			write := &fuseops.WriteFileOp{Inode: id, Offset: offset, Data: []byte("x")}
+			if err := server.fs.WriteFile(context.Background(), write); err != nil {
+				written <- offset
+				return
+			}
+			offset++
+		}
+	}()
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	expected := <-written
+	if b, _ := blobs.Get("a.txt"); int64(len(b.Data)) != expected {
+		t.Fatalf("expected %d bytes to be flushed, but got %d", expected, len(b.Data))
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	for _, leaseWrites := range []bool{false, true} {
+		server, blobs, cleanup := newTestFS(t, func(c *config.Config) { c.LeaseWrites = leaseWrites })
+		createDirty(t, server, "a.txt")
+
+		// Unmounting uploads every dirty file and releases any leases held.
+		server.fs.Destroy()
+		b, ok := blobs.Get("a.txt")
+		if !ok || string(b.Data) != "a.txt" {
+			t.Fatalf("lease writes %v: expected a.txt to be flushed, but got %q", leaseWrites, b.Data)
+		}
+		if b.LeaseID != "" {
+			t.Fatalf("lease writes %v: expected the lease to be released", leaseWrites)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,166 @@
+package fs
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// snapshotsDirName is the hidden directory in each directory holding the
+	// snapshots of its files, as .snapshots/<timestamp>/<name>. It can be
+	// looked up but isn't listed.
+	snapshotsDirName = ".snapshots"
+
+	// snapshotXattr is the snapshot a file in .snapshots was taken at.
+	// Setting it on any other file takes a snapshot of it.
+	snapshotXattr = "user.lightning.snapshot"
+)
+
+// lookUpSnapshots returns the .snapshots directory of a directory, creating it
+// the first time it's looked up. ok is false if the directory can't have one.
+func (fs *lightningFS) lookUpSnapshots(parentID fuseops.InodeID, parent *iNode) (id fuseops.InodeID, dir *iNode, ok bool) {
+	if parent.container == nil || parent.isImmutable() {
+		return 0, nil, false
+	}
+	if parent.snapshotsDir != 0 {
+		return parent.snapshotsDir, fs.inodes[parent.snapshotsDir], true
+	}
+
+	id, dir = fs.addHiddenDir(parentID, parent)
+	dir.snapshots = true
+	parent.snapshotsDir = id
+	return id, dir, true
+}
+
+// addHiddenDir allocates a read-only directory alongside the blobs of its
+// parent which can be looked up but isn't listed in it.
+func (fs *lightningFS) addHiddenDir(parentID fuseops.InodeID, parent *iNode) (fuseops.InodeID, *iNode) {
+	now := time.Now()
+	id, dir := fs.allocateInode(fuseops.InodeAttributes{
+		Nlink:  1,
+		Mode:   0500 | os.ModeDir,
+		Atime:  now,
+		Mtime:  now,
+		Ctime:  now,
+		Crtime: now,
+		Uid:    fs.uid,
+		Gid:    fs.gid,
+	})
+	dir.name = parent.name
+	dir.parent = parentID
+	dir.container = parent.container
+	return id, dir
+}
+
+// listSnapshots populates a .snapshots directory with a directory for each
+// snapshot taken of the files alongside it, and refreshes it once its entries
+// have expired.
+func (fs *lightningFS) listSnapshots(ctx context.Context, id fuseops.InodeID, dir *iNode) error {
+	if dir.listed && time.Since(dir.validated) < fs.entryTTL {
+		return nil
+	}
+
+	prefix := ""
+	if dir.name != "" {
+		prefix = dir.name + blobDelimiter
+	}
+
+	// Snapshots can only be listed flat, so blobs in subdirectories, which
+	// have snapshots directories of their own, are skipped.
+	c := dir.container
+	var items []azblob.BlobItem
+	err := fs.unlocked(func() error {
+		for marker := (azblob.Marker{}); marker.NotDone(); {
+			resp, err := c.url.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
+				Prefix:  prefix,
+				Details: azblob.BlobListingDetails{Metadata: true, Snapshots: true},
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to list snapshots of %q", prefix)
+			}
+			marker = resp.NextMarker
+			items = append(items, resp.Segment.BlobItems...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.Snapshot == "" || strings.Contains(strings.TrimPrefix(item.Name, prefix), blobDelimiter) {
+			continue
+		}
+		seen[item.Snapshot] = true
+
+		snapID, _, ok := dir.LookUpChild(item.Snapshot)
+		if !ok {
+			snapID, _ = fs.addSnapshotDir(id, dir, item.Snapshot)
+		}
+		snapDir := fs.inodes[snapID]
+
+		name := strings.TrimPrefix(item.Name, prefix)
+		if _, _, ok := snapDir.LookUpChild(name); !ok {
+			_, child := fs.addBlob(snapID, snapDir, name, item)
+			child.snapshot = item.Snapshot
+			child.attrs.Mode = 0400
+		}
+	}
+
+	// Snapshots never change, but they can be deleted.
+	if dir.listed {
+		for _, e := range dir.entries {
+			if e.Type != fuseutil.DT_Unknown && !seen[e.Name] {
+				dir.removeChild(e.Name)
+			}
+		}
+	}
+
+	dir.listed = true
+	dir.validated = time.Now()
+	return nil
+}
+
+// addSnapshotDir adds the directory for a snapshot to a .snapshots directory.
+// It's populated as the .snapshots directory is listed.
+func (fs *lightningFS) addSnapshotDir(parentID fuseops.InodeID, parent *iNode, snapshot string) (fuseops.InodeID, *iNode) {
+	id, dir := fs.addDir(parentID, parent, snapshot)
+	dir.name = parent.name
+	dir.snapshot = snapshot
+	dir.listed = true
+	dir.attrs.Mode = 0500 | os.ModeDir
+	return id, dir
+}
+
+// createSnapshot uploads any changes to a file and then snapshots its blob.
+func (fs *lightningFS) createSnapshot(ctx context.Context, in *iNode) error {
+	if !in.isFile() {
+		return fuse.EINVAL
+	}
+	if err := fs.syncFile(ctx, in); err != nil {
+		return err
+	}
+
+	blobURL := in.container.url.NewBlobURL(in.name)
+	resp, err := blobURL.CreateSnapshot(ctx, nil, azblob.BlobAccessConditions{LeaseAccessConditions: in.leaseAccessConditions()})
+	if err != nil {
+		return errors.Wrapf(err, "failed to snapshot %s", in.name)
+	}
+	log.Printf("snapshotted %s at %s", in.name, resp.Snapshot())
+
+	// Make the new snapshot visible.
+	if parent := fs.inodes[in.parent]; parent.snapshotsDir != 0 {
+		fs.inodes[parent.snapshotsDir].validated = time.Time{}
+	}
+	return nil
+}
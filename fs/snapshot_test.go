@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestListSnapshots(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	fs := server.fs
+
+	const (
+		first  = "2019-01-01T00:00:00.0000000Z"
+		second = "2019-01-02T00:00:00.0000000Z"
+	)
+	for _, b := range []blobtest.Blob{
+		{Name: "a.txt", Data: []byte("now")},
+		{Name: "a.txt", Snapshot: first, Data: []byte("then")},
+		{Name: "dir/b.txt", Data: []byte("now")},
+		{Name: "dir/b.txt", Snapshot: second, Data: []byte("then")},
+	} {
+		blobs.Put(b)
+	}
+
+	for _, test := range []struct {
+		parent   string
+		snapshot string
+		name     string
+		skipped  string
+	}{
+		{"", first, "a.txt", second},
+		{"dir", second, "b.txt", first},
+	} {
+		parentID := fuseops.InodeID(fuseops.RootInodeID)
+		if test.parent != "" {
+			op := &fuseops.LookUpInodeOp{Parent: parentID, Name: test.parent}
+			if err := fs.LookUpInode(context.Background(), op); err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			parentID = op.Entry.Child
+		}
+		op := &fuseops.LookUpInodeOp{Parent: parentID, Name: snapshotsDirName}
+		if err := fs.LookUpInode(context.Background(), op); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		fs.mu.Lock()
+		dir := fs.inodes[op.Entry.Child]
+		err := fs.listDir(context.Background(), op.Entry.Child, dir)
+		fs.mu.Unlock()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		snapDir := lookUp(fs, dir, test.snapshot)
+		if snapDir == nil {
+			t.Fatalf("expected %s/%s/%s to be listed", test.parent, snapshotsDirName, test.snapshot)
+		}
+		if child := lookUp(fs, snapDir, test.name); child == nil || child.snapshot != test.snapshot {
+			t.Fatalf("expected %s in snapshot %s", test.name, test.snapshot)
+		}
+		// Snapshots of blobs in other directories aren't listed.
+		if lookUp(fs, dir, test.skipped) != nil {
+			t.Fatalf("expected snapshot %s not to be listed under %s", test.skipped, test.parent)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package fs
+
+import (
+	"context"
+	"path"
+
+	"github.com/ehotinger/lightningfs/config"
+)
+
+// Stats summarizes the state of a mounted file system.
+type Stats struct {
+	// Containers are the containers mounted, as account/container/prefix. An
+	// account mounted with all of its containers is account/*.
+	Containers []string
+
+	// DirtyFiles is the number of files with changes waiting to be uploaded,
+	// and DirtyBytes the number of bytes of data they hold.
+	DirtyFiles int
+	DirtyBytes int64
+
+	// MemoryBytes is the file data held in memory, and StagedBytes the file
+	// data spilled to the staging path.
+	MemoryBytes int64
+	StagedBytes int64
+
+	// Busy is set if the files couldn't be counted in time, when DirtyFiles,
+	// DirtyBytes and StagedBytes are zero.
+	Busy bool
+}
+
+// Stats returns the current state of the file system. Files can only be
+// counted under the file system's lock, which can be held while talking to
+// the Blob service, so they're left out if ctx is done first.
+func (s *Server) Stats(ctx context.Context) Stats {
+	fs := s.fs
+	stats := Stats{Containers: fs.containers}
+
+	fs.stager.mu.Lock()
+	stats.MemoryBytes = fs.stager.used
+	fs.stager.mu.Unlock()
+
+	files := make(chan Stats, 1)
+	go func() {
+		fs.mu.RLock()
+		defer fs.mu.RUnlock()
+		files <- fs.fileStats()
+	}()
+	select {
+	case f := <-files:
+		stats.DirtyFiles, stats.DirtyBytes, stats.StagedBytes = f.DirtyFiles, f.DirtyBytes, f.StagedBytes
+	case <-ctx.Done():
+		stats.Busy = true
+	}
+	return stats
+}
+
+// fileStats counts the dirty and staged files.
+func (fs *lightningFS) fileStats() Stats {
+	var stats Stats
+	for _, inode := range fs.inodes {
+		if inode == nil || inode.contents == nil {
+			continue
+		}
+		allocated := inode.contents.Allocated()
+		if inode.dirty {
+			stats.DirtyFiles++
+			stats.DirtyBytes += allocated
+		}
+		if inode.contents.f != nil {
+			stats.StagedBytes += allocated
+		}
+	}
+	return stats
+}
+
+// containerNames describes the containers a config mounts.
+func containerNames(cfg *config.Config) []string {
+	mounts := cfg.Mounts
+	if len(mounts) == 0 {
+		mounts = []config.Mount{{Credentials: cfg.Credentials, ContainerName: cfg.ContainerName, Prefix: cfg.Prefix}}
+	}
+
+	names := make([]string, len(mounts))
+	for i, m := range mounts {
+		container := m.ContainerName
+		if container == "" {
+			container = "*"
+		}
+		names[i] = path.Join(m.AzureAccountName, container, m.Prefix)
+	}
+	return names
+}
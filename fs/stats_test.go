@@ -0,0 +1,49 @@
+package fs
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ehotinger/lightningfs/config"
+)
+
+func TestContainerNames(t *testing.T) {
+	for _, test := range []struct {
+		cfg      *config.Config
+		expected []string
+	}{
+		{config.NewConfig("account", "", "container", ""), []string{"account/container"}},
+		{&config.Config{Credentials: config.Credentials{AzureAccountName: "account"}, Prefix: "data"}, []string{"account/*/data"}},
+		{&config.Config{Mounts: []config.Mount{
+			{Credentials: config.Credentials{AzureAccountName: "a"}, ContainerName: "c", Prefix: "p"},
+			{Credentials: config.Credentials{AzureAccountName: "b"}},
+		}}, []string{"a/c/p", "b/*"}},
+	} {
+		if actual := containerNames(test.cfg); !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("expected %v but got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	server, _, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	createDirty(t, server, "a.txt")
+
+	stats := server.Stats(context.Background())
+	if stats.Busy || stats.DirtyFiles != 1 || stats.DirtyBytes == 0 || !reflect.DeepEqual(stats.Containers, []string{"account/container"}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// While an upload holds the lock, everything but the files is reported.
+	server.fs.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stats = server.Stats(ctx)
+	server.fs.mu.Unlock()
+	if !stats.Busy || stats.DirtyFiles != 0 || len(stats.Containers) != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,182 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"syscall"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/errors"
+)
+
+const (
+	// blockSize is the size of the blocks files are staged in.
+	blockSize = 4 << 20
+)
+
+// blockID returns a base64 encoded block ID. All the IDs within a blob must
+// have the same length, so kind distinguishes data blocks from zero blocks.
+func blockID(kind byte, n int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%c%015d", kind, n)))
+}
+
+// syncFile uploads the contents of a dirty file to its blob, resolving any
+// conflicting changes made by others according to the conflict policy. It's
+// called with mu held, which is released while uploading the contents of
+// block blobs.
+func (fs *lightningFS) syncFile(ctx context.Context, in *iNode) error {
+	fs.waitTransfer(in)
+	if !in.dirty {
+		return nil
+	}
+	done := fs.startTransfer(in)
+	defer done()
+
+	err := fs.syncBlob(ctx, in)
+	if isLeased(err) {
+		log.Printf("failed to upload %s: it's being written by another mount", in.name)
+		return syscall.EBUSY
+	}
+	if !isConflict(err) {
+		return err
+	}
+	return fs.resolveConflict(ctx, in, err)
+}
+
+// syncBlob uploads the contents of a file to its blob.
+func (fs *lightningFS) syncBlob(ctx context.Context, in *iNode) error {
+	switch in.blobType {
+	case azblob.BlobPageBlob:
+		return fs.syncPageBlob(ctx, in)
+	case azblob.BlobAppendBlob:
+		return fs.syncAppendBlob(ctx, in)
+	default:
+		if fs.dedup {
+			return fs.syncManifest(ctx, in)
+		}
+		return fs.syncBlockBlob(ctx, in)
+	}
+}
+
+// syncBlockBlob uploads the contents of a file to its block blob. Blocks which
+// lie entirely within a hole aren't read; instead a single zero block of each
+// length is staged and referenced as many times as needed in the block list.
+func (fs *lightningFS) syncBlockBlob(ctx context.Context, in *iNode) error {
+	blobURL := in.container.url.NewBlockBlobURL(in.name)
+	size := in.contents.Size()
+
+	// Encrypted blobs are larger than their files, so their size is recorded.
+	var (
+		aead     cipher.AEAD
+		metadata = azblob.Metadata{}
+	)
+	if fs.encryptionKey != nil {
+		var err error
+		if aead, metadata, err = fs.encryptionKey.newDataKey(); err != nil {
+			return err
+		}
+		metadata[sizeMetadataKey] = strconv.FormatInt(size, 10)
+	}
+
+	compress := fs.shouldCompress(in)
+	var lengths []int64
+
+	// The contents are uploaded without holding the lock, so everything
+	// needed from the inode is read first.
+	var (
+		name = in.name
+		lac  = in.leaseAccessConditions()
+		ac   = fs.accessConditions(in)
+		resp *azblob.BlockBlobCommitBlockListResponse
+	)
+	err := fs.unlocked(func() error {
+		var (
+			ids    []string
+			zeros  = make(map[int64]string)
+			buf    = make([]byte, min64(blockSize, size))
+			zero   = make([]byte, min64(blockSize, size))
+			whole  = md5.New()
+			sealed []byte
+		)
+		for off, n := int64(0), int64(0); off < size; off, n = off+blockSize, n+1 {
+			length := min64(blockSize, size-off)
+
+			// Encrypted zeros differ from chunk to chunk, so holes can't share a
+			// block when encrypting. Compressed zeros are tiny anyway.
+			if pos, ok := in.contents.SeekData(off); (!ok || pos >= off+length) && aead == nil && !compress {
+				whole.Write(zero[:length])
+
+				id, ok := zeros[length]
+				if !ok {
+					id = blockID('z', length)
+					if _, err := blobURL.StageBlock(ctx, id, bytes.NewReader(zero[:length]), lac, md5Sum(zero[:length])); err != nil {
+						return errors.Wrapf(err, "failed to stage zero block for %s", name)
+					}
+					zeros[length] = id
+				}
+				ids = append(ids, id)
+				continue
+			}
+
+			data := buf[:length]
+			if _, err := in.contents.ReadAt(data, off); err != nil && err != io.EOF {
+				return errors.Wrapf(err, "failed to read %s", name)
+			}
+			switch {
+			case compress:
+				var err error
+				if data, err = compressChunk(data); err != nil {
+					return errors.Wrapf(err, "failed to compress %s", name)
+				}
+				lengths = append(lengths, int64(len(data)))
+			case aead != nil:
+				sealed = sealChunks(aead, sealed[:0], data, off/encryptionChunkSize)
+				data = sealed
+			}
+			whole.Write(data)
+
+			id := blockID('d', n)
+			if _, err := blobURL.StageBlock(ctx, id, bytes.NewReader(data), lac, md5Sum(data)); err != nil {
+				return errors.Wrapf(err, "failed to stage block %d for %s", n, name)
+			}
+			ids = append(ids, id)
+		}
+
+		if compress {
+			metadata = compressionMetadata(size, lengths)
+		}
+
+		// The MD5 of the whole blob is stored so that downloads can be verified.
+		headers := azblob.BlobHTTPHeaders{ContentMD5: whole.Sum(nil)}
+		var err error
+		if resp, err = blobURL.CommitBlockList(ctx, ids, headers, metadata, ac); err != nil {
+			return errors.Wrapf(err, "failed to commit %s", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	created := !in.exists
+	in.etag = resp.ETag()
+	in.exists = true
+	in.dirty = false
+	in.chunks, in.chunk = lengths, nil
+
+	// New blobs otherwise get the account's default tier.
+	if created && fs.defaultTier != azblob.AccessTierNone {
+		if _, err := blobURL.SetTier(ctx, fs.defaultTier, in.leaseAccessConditions()); err != nil {
+			return errors.Wrapf(err, "failed to set the access tier of %s", in.name)
+		}
+		in.setTier(fs.defaultTier, azblob.ArchiveStatusNone)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestSyncBlockBlobHoles(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+
+	var zeroBlocks int
+	blobs.Hook = func(r *http.Request) int {
+		if r.URL.Query().Get("comp") == "block" {
+			id, _ := base64.StdEncoding.DecodeString(r.URL.Query().Get("blockid"))
+			if len(id) > 0 && id[0] == 'z' {
+				zeroBlocks++
+			}
+		}
+		return 0
+	}
+
+	// Three whole blocks of hole lie between the data.
+	ctx := context.Background()
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "sparse", Mode: 0600}
+	if err := server.fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := create.Entry.Child
+	for _, write := range []*fuseops.WriteFileOp{
+		{Inode: id, Handle: create.Handle, Data: []byte("start")},
+		{Inode: id, Handle: create.Handle, Offset: 4*blockSize + 10, Data: []byte("end")},
+	} {
+		if err := server.fs.WriteFile(ctx, write); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	if err := server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	b, _ := blobs.Get("sparse")
+	zero := blockID('z', blockSize)
+	var references int
+	for _, id := range b.BlockIDs {
+		if id == zero {
+			references++
+		}
+	}
+	if zeroBlocks != 1 || references != 3 || len(b.BlockIDs) != 5 {
+		t.Fatalf("expected 1 zero block staged and referenced 3 times out of 5, but got %d staged and referenced %d times out of %d",
+			zeroBlocks, references, len(b.BlockIDs))
+	}
+
+	expected := make([]byte, 4*blockSize+13)
+	copy(expected, "start")
+	copy(expected[4*blockSize+10:], "end")
+	actual, err := readFile(t, blobs, nil, "sparse")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("expected %d bytes to read back, but got %d different ones", len(expected), len(actual))
+	}
+}
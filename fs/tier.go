@@ -0,0 +1,89 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+const (
+	// tierXattr exposes the access tier of a file's blob. Setting it moves the
+	// blob to another tier; moving an archived blob out of the archive tier
+	// starts rehydrating it.
+	tierXattr = "user.lightning.tier"
+
+	// archiveStatusXattr exposes the progress of rehydrating an archived blob.
+	archiveStatusXattr = "user.lightning.archivestatus"
+)
+
+// parseTier validates an access tier, ignoring case.
+func parseTier(tier string) (azblob.AccessTierType, error) {
+	for _, t := range []azblob.AccessTierType{azblob.AccessTierNone, azblob.AccessTierHot, azblob.AccessTierCool, azblob.AccessTierArchive} {
+		if strings.EqualFold(tier, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("invalid access tier: %q", tier)
+}
+
+// setTier records the access tier of a file's blob.
+func (in *iNode) setTier(tier azblob.AccessTierType, status azblob.ArchiveStatusType) {
+	in.tier = tier
+	in.archiveStatus = status
+}
+
+// checkArchived returns ENODATA for files whose blobs are in the archive tier,
+// since they can't be read until they're rehydrated.
+func checkArchived(in *iNode) error {
+	if in.tier != azblob.AccessTierArchive {
+		return nil
+	}
+	if in.archiveStatus != azblob.ArchiveStatusNone {
+		log.Printf("%s is archived and is being rehydrated (%s)", in.name, in.archiveStatus)
+	} else {
+		log.Printf("%s is archived; set %s to %s or %s to rehydrate it", in.name, tierXattr, azblob.AccessTierHot, azblob.AccessTierCool)
+	}
+	return syscall.ENODATA
+}
+
+// setAccessTier moves a file's blob to another access tier, uploading any
+// changes first. Only block blobs have tiers.
+func (fs *lightningFS) setAccessTier(ctx context.Context, in *iNode, value string) error {
+	tier, err := parseTier(value)
+	if err != nil || tier == azblob.AccessTierNone {
+		return fuse.EINVAL
+	}
+	if !in.isFile() || in.blobType != azblob.BlobBlockBlob {
+		return fuse.EINVAL
+	}
+	if err := fs.syncFile(ctx, in); err != nil {
+		return err
+	}
+	if tier == in.tier {
+		return nil
+	}
+
+	blobURL := in.container.url.NewBlobURL(in.name)
+	if _, err := blobURL.SetTier(ctx, tier, in.leaseAccessConditions()); err != nil {
+		return errors.Wrapf(err, "failed to set the access tier of %s", in.name)
+	}
+
+	// Rehydrating takes hours; the blob stays archived until it's done.
+	if in.tier == azblob.AccessTierArchive {
+		status := azblob.ArchiveStatusRehydratePendingToHot
+		if tier == azblob.AccessTierCool {
+			status = azblob.ArchiveStatusRehydratePendingToCool
+		}
+		in.setTier(in.tier, status)
+		log.Printf("rehydrating %s to %s", in.name, tier)
+		return nil
+	}
+	in.setTier(tier, azblob.ArchiveStatusNone)
+	return nil
+}
@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestAccessTiers(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	fs := server.fs
+
+	ctx := context.Background()
+	blobs.Put(blobtest.Blob{Name: "hot.txt", Data: []byte("hot"), AccessTier: azblob.AccessTierHot})
+	blobs.Put(blobtest.Blob{Name: "archived.txt", Data: []byte("archived"), AccessTier: azblob.AccessTierArchive})
+
+	lookUpID := func(name string) fuseops.InodeID {
+		op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+		if err := fs.LookUpInode(ctx, op); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		return op.Entry.Child
+	}
+	getXattr := func(id fuseops.InodeID, name string) (string, error) {
+		op := &fuseops.GetXattrOp{Inode: id, Name: name, Dst: make([]byte, 64)}
+		err := fs.GetXattr(ctx, op)
+		return string(op.Dst[:op.BytesRead]), err
+	}
+	setTier := func(id fuseops.InodeID, tier string) error {
+		return fs.SetXattr(ctx, &fuseops.SetXattrOp{Inode: id, Name: tierXattr, Value: []byte(tier)})
+	}
+
+	// Setting the tier moves the blob, without changing its contents.
+	hot := lookUpID("hot.txt")
+	if tier, err := getXattr(hot, tierXattr); err != nil || tier != "Hot" {
+		t.Fatalf("expected the Hot tier, but got %q, %v", tier, err)
+	}
+	for _, test := range []struct {
+		tier string
+		err  error
+		want azblob.AccessTierType
+	}{
+		{"cool", nil, azblob.AccessTierCool},
+		{"Lukewarm", fuse.EINVAL, azblob.AccessTierCool},
+		{"", fuse.EINVAL, azblob.AccessTierCool},
+		{"Hot", nil, azblob.AccessTierHot},
+	} {
+		if err := setTier(hot, test.tier); err != test.err {
+			t.Fatalf("%q: expected %v, but got %v", test.tier, test.err, err)
+		}
+		if b, _ := blobs.Get("hot.txt"); b.AccessTier != test.want || string(b.Data) != "hot" {
+			t.Fatalf("%q: expected hot.txt in the %s tier, but got %s holding %q", test.tier, test.want, b.AccessTier, b.Data)
+		}
+		if tier, _ := getXattr(hot, tierXattr); tier != string(test.want) {
+			t.Fatalf("%q: expected %s, but got %q", test.tier, test.want, tier)
+		}
+	}
+
+	// Archived blobs can't be read until they're rehydrated, which setting
+	// the tier only starts.
+	archived := lookUpID("archived.txt")
+	read := func() error {
+		return fs.ReadFile(ctx, &fuseops.ReadFileOp{Inode: archived, Dst: make([]byte, 16)})
+	}
+	if err := read(); err != syscall.ENODATA {
+		t.Fatalf("expected ENODATA, but got %v", err)
+	}
+	if _, err := getXattr(archived, archiveStatusXattr); err != fuse.ENOATTR {
+		t.Fatalf("expected no archive status, but got %v", err)
+	}
+	if err := setTier(archived, "Hot"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if b, _ := blobs.Get("archived.txt"); b.ArchiveStatus != azblob.ArchiveStatusRehydratePendingToHot {
+		t.Fatalf("expected archived.txt to be rehydrating, but got %q", b.ArchiveStatus)
+	}
+	if tier, _ := getXattr(archived, tierXattr); tier != "Archive" {
+		t.Fatalf("expected the Archive tier while rehydrating, but got %q", tier)
+	}
+	if status, err := getXattr(archived, archiveStatusXattr); err != nil || status != "rehydrate-pending-to-hot" {
+		t.Fatalf("expected rehydrate-pending-to-hot, but got %q, %v", status, err)
+	}
+	if err := read(); err != syscall.ENODATA {
+		t.Fatalf("expected ENODATA while rehydrating, but got %v", err)
+	}
+
+	// Another mount sees the status when listing the blob.
+	if _, err := readFile(t, blobs, nil, "archived.txt"); err != syscall.ENODATA {
+		t.Fatalf("expected ENODATA, but got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package fs
+
+import (
+	"context"
+	"syscall"
+)
+
+// unlocked calls f without holding mu, which the caller holds. Anything may
+// change in the meantime, so f mustn't touch the file system and callers must
+// check their assumptions again afterwards.
+func (fs *lightningFS) unlocked(f func() error) error {
+	fs.mu.Unlock()
+	defer fs.mu.Lock()
+	return f()
+}
+
+// startTransfer marks a file's contents as being uploaded or downloaded
+// without holding mu. Nothing else may change the file until the returned
+// function is called, with mu held, to finish the transfer. There mustn't be
+// a transfer of the file in progress already.
+func (fs *lightningFS) startTransfer(in *iNode) (done func()) {
+	transfer := make(chan struct{})
+	in.transfer = transfer
+	return func() {
+		in.transfer = nil
+		close(transfer)
+	}
+}
+
+// waitTransfer waits for any transfer of a file's contents to finish. It's
+// called with mu held, which is released while waiting.
+func (fs *lightningFS) waitTransfer(in *iNode) {
+	for in.transfer != nil {
+		transfer := in.transfer
+		fs.mu.Unlock()
+		<-transfer
+		fs.mu.Lock()
+	}
+}
+
+// prepareWrite loads a file and waits for any upload of it to finish, so that
+// it can be changed. Both release mu, after which Shutdown may have flushed,
+// so it checks again whether changes are rejected.
+func (fs *lightningFS) prepareWrite(ctx context.Context, in *iNode) error {
+	for {
+		if err := fs.loadFile(ctx, in); err != nil {
+			return err
+		}
+		if in.transfer == nil {
+			break
+		}
+		fs.waitTransfer(in)
+	}
+	if fs.rejectsWrites() {
+		return syscall.EROFS
+	}
+	return nil
+}
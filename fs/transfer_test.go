@@ -0,0 +1,108 @@
+package fs
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// blockRequests makes the requests matching match wait until the returned
+// function is called. started is closed once the first of them arrives.
+func blockRequests(blobs *blobtest.Server, match func(r *http.Request) bool) (started <-chan struct{}, release func()) {
+	var (
+		once     sync.Once
+		arrived  = make(chan struct{})
+		released = make(chan struct{})
+	)
+	blobs.Hook = func(r *http.Request) int {
+		if match(r) {
+			once.Do(func() { close(arrived) })
+			<-released
+		}
+		return 0
+	}
+	return arrived, func() { close(released) }
+}
+
+func TestUploadWithoutLock(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+
+	ctx := context.Background()
+	id := createDirty(t, server, "a.txt")
+	started, release := blockRequests(blobs, func(r *http.Request) bool {
+		return r.URL.Query().Get("comp") == "blocklist"
+	})
+
+	synced := make(chan error, 1)
+	go func() {
+		synced <- server.fs.SyncFile(ctx, &fuseops.SyncFileOp{Inode: id})
+	}()
+	<-started
+
+	// Other operations carry on while the upload is in progress, but writes
+	// to the file wait for it to finish.
+	if err := server.fs.GetInodeAttributes(ctx, &fuseops.GetInodeAttributesOp{Inode: id}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		written <- server.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: id, Data: []byte("changed")})
+	}()
+	release()
+	if err := <-synced; err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	b, _ := blobs.Get("a.txt")
+	server.fs.mu.Lock()
+	dirty := server.fs.inodes[id].dirty
+	server.fs.mu.Unlock()
+	if strings.HasPrefix(string(b.Data), "changed") || !dirty {
+		t.Fatalf("expected the write to wait for the upload and be left to sync, but got %q uploaded and dirty %v", b.Data, dirty)
+	}
+}
+
+func TestDownloadWithoutLock(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+
+	ctx := context.Background()
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("one")})
+	blobs.Put(blobtest.Blob{Name: "b.txt", Data: []byte("two")})
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.txt"}
+	if err := server.fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	id := lookUp.Entry.Child
+
+	started, release := blockRequests(blobs, func(r *http.Request) bool {
+		return r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/a.txt")
+	})
+	read := &fuseops.ReadFileOp{Inode: id, Dst: make([]byte, 16)}
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- server.fs.ReadFile(ctx, read)
+	}()
+	<-started
+
+	// Other files can be looked up while a.txt is downloading.
+	if err := server.fs.LookUpInode(ctx, &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "b.txt"}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	release()
+	if err := <-readErr; err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got := string(read.Dst[:read.BytesRead]); got != "one" {
+		t.Fatalf("expected %q but got %q", "one", got)
+	}
+}
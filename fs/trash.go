@@ -0,0 +1,134 @@
+package fs
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// trashDirName is the hidden directory in each directory holding its soft
+	// deleted files. Moving a file out of it restores it. Like .snapshots, it
+	// can be looked up but isn't listed.
+	trashDirName = ".trash"
+)
+
+// lookUpTrash returns the .trash directory of a directory, creating it the
+// first time it's looked up. ok is false if the directory can't have one.
+func (fs *lightningFS) lookUpTrash(parentID fuseops.InodeID, parent *iNode) (id fuseops.InodeID, dir *iNode, ok bool) {
+	if parent.container == nil || parent.isImmutable() {
+		return 0, nil, false
+	}
+	if parent.trashDir != 0 {
+		return parent.trashDir, fs.inodes[parent.trashDir], true
+	}
+
+	id, dir = fs.addHiddenDir(parentID, parent)
+	dir.trash = true
+	parent.trashDir = id
+	return id, dir, true
+}
+
+// listTrash populates a .trash directory with the soft deleted blobs alongside
+// it, and refreshes it once its entries have expired. It's empty unless soft
+// delete is enabled on the account.
+func (fs *lightningFS) listTrash(ctx context.Context, id fuseops.InodeID, dir *iNode) error {
+	if dir.listed && time.Since(dir.validated) < fs.entryTTL {
+		return nil
+	}
+
+	prefix := ""
+	if dir.name != "" {
+		prefix = dir.name + blobDelimiter
+	}
+
+	c := dir.container
+	var items []azblob.BlobItem
+	err := fs.unlocked(func() error {
+		for marker := (azblob.Marker{}); marker.NotDone(); {
+			resp, err := c.url.ListBlobsHierarchySegment(ctx, marker, blobDelimiter, azblob.ListBlobsSegmentOptions{
+				Prefix:  prefix,
+				Details: azblob.BlobListingDetails{Metadata: true, Deleted: true},
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to list deleted blobs of %q", prefix)
+			}
+			marker = resp.NextMarker
+			items = append(items, resp.Segment.BlobItems...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if !item.Deleted || item.Snapshot != "" {
+			continue
+		}
+		name := strings.TrimPrefix(item.Name, prefix)
+		seen[name] = true
+		if _, _, ok := dir.LookUpChild(name); !ok {
+			_, child := fs.addBlob(id, dir, name, item)
+			child.deleted = true
+			child.attrs.Mode = 0400
+		}
+	}
+
+	// Blobs leave the trash when they're restored or expire.
+	if dir.listed {
+		for _, e := range dir.entries {
+			if e.Type != fuseutil.DT_Unknown && !seen[e.Name] {
+				dir.removeChild(e.Name)
+			}
+		}
+	}
+
+	dir.listed = true
+	dir.validated = time.Now()
+	return nil
+}
+
+// restore undeletes a soft deleted blob, moving its file out of .trash. Blobs
+// are restored under their original names, so it can only be moved back to
+// where it came from.
+func (fs *lightningFS) restore(ctx context.Context, trash *iNode, name string, newParentID fuseops.InodeID, newName string) error {
+	childID, _, ok := trash.LookUpChild(name)
+	if !ok {
+		return fuse.ENOENT
+	}
+	child := fs.inodes[childID]
+
+	if newParentID != trash.parent || newName != name {
+		log.Printf("rejecting restore of %s under a different name: deleted blobs are restored where they were", child.name)
+		return fuse.EINVAL
+	}
+	parent := fs.inodes[trash.parent]
+	if err := fs.listDir(ctx, trash.parent, parent); err != nil {
+		return err
+	}
+	if _, _, ok := parent.LookUpChild(name); ok {
+		return fuse.EEXIST
+	}
+
+	blobURL := child.container.url.NewBlobURL(child.name)
+	if _, err := blobURL.Undelete(ctx); err != nil {
+		return errors.Wrapf(err, "failed to undelete %s", child.name)
+	}
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get properties of %s", child.name)
+	}
+
+	trash.removeChild(name)
+	fs.addBlob(trash.parent, parent, name, blobItemFromProperties(child.name, props))
+	return nil
+}
@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ehotinger/lightningfs/internal/blobtest"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestTrash(t *testing.T) {
+	server, blobs, cleanup := newTestFS(t, nil)
+	defer cleanup()
+	fs := server.fs
+
+	ctx := context.Background()
+	blobs.Put(blobtest.Blob{Name: "a.txt", Data: []byte("deleted"), Deleted: true})
+	blobs.Put(blobtest.Blob{Name: "b.txt", Data: []byte("deleted"), Deleted: true})
+	blobs.Put(blobtest.Blob{Name: "b.txt", Data: []byte("replaced")})
+
+	trash := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: trashDirName}
+	if err := fs.LookUpInode(ctx, trash); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	trashID := trash.Entry.Child
+	for _, name := range []string{"a.txt", "b.txt"} {
+		op := &fuseops.LookUpInodeOp{Parent: trashID, Name: name}
+		if err := fs.LookUpInode(ctx, op); err != nil {
+			t.Fatalf("expected %s to be listed in the trash, but got %v", name, err)
+		}
+		if op.Entry.Attributes.Mode != 0400 {
+			t.Fatalf("expected %s to be read-only, but got %v", name, op.Entry.Attributes.Mode)
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		oldName   string
+		newParent fuseops.InodeID
+		newName   string
+		err       error
+	}{
+		{"different name", "a.txt", fuseops.RootInodeID, "c.txt", fuse.EINVAL},
+		{"different directory", "a.txt", trashID, "a.txt", fuse.EINVAL},
+		{"replaced", "b.txt", fuseops.RootInodeID, "b.txt", fuse.EEXIST},
+		{"missing", "c.txt", fuseops.RootInodeID, "c.txt", fuse.ENOENT},
+		{"restore", "a.txt", fuseops.RootInodeID, "a.txt", nil},
+	} {
+		op := &fuseops.RenameOp{OldParent: trashID, OldName: test.oldName, NewParent: test.newParent, NewName: test.newName}
+		if err := fs.Rename(ctx, op); err != test.err {
+			t.Fatalf("%s: expected %v, but got %v", test.name, test.err, err)
+		}
+	}
+
+	// The restored file leaves the trash and can be read where it was.
+	if b, ok := blobs.Get("a.txt"); !ok || string(b.Data) != "deleted" {
+		t.Fatalf("expected a.txt to be undeleted")
+	}
+	if err := fs.LookUpInode(ctx, &fuseops.LookUpInodeOp{Parent: trashID, Name: "a.txt"}); err != fuse.ENOENT {
+		t.Fatalf("expected a.txt to leave the trash, but got %v", err)
+	}
+	data, err := readFile(t, blobs, nil, "a.txt")
+	if err != nil || string(data) != "deleted" {
+		t.Fatalf("expected to read back %q, but got %q and %v", "deleted", data, err)
+	}
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a.txt"}
+	if err := fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("expected a.txt to be restored, but got %v", err)
+	}
+}
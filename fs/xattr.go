@@ -0,0 +1,151 @@
+package fs
+
+import (
+	"context"
+	"sort"
+	"syscall"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/jacobsa/fuse"
+	"github.com/pkg/errors"
+)
+
+const (
+	// blobTypeXattr exposes the type of blob a file is stored as. Setting it
+	// changes the type the next time the file is uploaded.
+	blobTypeXattr = "user.lightning.blobtype"
+)
+
+// getXattr returns the value of an extended attribute.
+func (fs *lightningFS) getXattr(in *iNode, name string) ([]byte, error) {
+	switch name {
+	case blobTypeXattr:
+		if !in.isFile() {
+			return nil, fuse.ENOATTR
+		}
+		return []byte(in.blobType), nil
+	case snapshotXattr:
+		if in.snapshot == "" {
+			return nil, fuse.ENOATTR
+		}
+		return []byte(in.snapshot), nil
+	case tierXattr:
+		if in.tier == azblob.AccessTierNone {
+			return nil, fuse.ENOATTR
+		}
+		return []byte(in.tier), nil
+	case archiveStatusXattr:
+		if in.archiveStatus == azblob.ArchiveStatusNone {
+			return nil, fuse.ENOATTR
+		}
+		return []byte(in.archiveStatus), nil
+	}
+
+	value, ok := in.xattrs[name]
+	if !ok {
+		return nil, fuse.ENOATTR
+	}
+	return value, nil
+}
+
+// listXattrs returns the names of the extended attributes of an inode.
+func (fs *lightningFS) listXattrs(in *iNode) []string {
+	var names []string
+	if in.isFile() {
+		names = append(names, blobTypeXattr)
+	}
+	if in.snapshot != "" {
+		names = append(names, snapshotXattr)
+	}
+	if in.tier != azblob.AccessTierNone {
+		names = append(names, tierXattr)
+	}
+	if in.archiveStatus != azblob.ArchiveStatusNone {
+		names = append(names, archiveStatusXattr)
+	}
+	for name := range in.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setXattr sets the value of an extended attribute.
+func (fs *lightningFS) setXattr(ctx context.Context, in *iNode, name string, value []byte) error {
+	switch name {
+	case blobTypeXattr:
+		if !in.isFile() {
+			return fuse.EINVAL
+		}
+		return fs.setBlobType(ctx, in, azblob.BlobType(value))
+	case snapshotXattr:
+		return fs.createSnapshot(ctx, in)
+	case tierXattr:
+		return fs.setAccessTier(ctx, in, string(value))
+	case archiveStatusXattr:
+		return fuse.EINVAL
+	}
+
+	in.xattrs[name] = append([]byte(nil), value...)
+	return nil
+}
+
+// removeXattr removes an extended attribute.
+func (fs *lightningFS) removeXattr(in *iNode, name string) error {
+	switch name {
+	case blobTypeXattr, snapshotXattr, tierXattr, archiveStatusXattr:
+		return fuse.EINVAL
+	}
+
+	if _, ok := in.xattrs[name]; !ok {
+		return fuse.ENOATTR
+	}
+	delete(in.xattrs, name)
+	return nil
+}
+
+// setBlobType changes the type of blob a file is stored as. A blob's type
+// can't be changed in place, so any existing blob is deleted and the file is
+// uploaded afresh.
+func (fs *lightningFS) setBlobType(ctx context.Context, in *iNode, blobType azblob.BlobType) error {
+	switch blobType {
+	case azblob.BlobBlockBlob, azblob.BlobPageBlob, azblob.BlobAppendBlob:
+	default:
+		return fuse.EINVAL
+	}
+	if blobType == in.blobType {
+		return nil
+	}
+	if fs.encryptionKey != nil && blobType != azblob.BlobBlockBlob {
+		return syscall.EPERM
+	}
+	if err := fs.prepareWrite(ctx, in); err != nil {
+		return err
+	}
+
+	if in.exists {
+		blobURL := in.container.url.NewBlobURL(in.name)
+		if _, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, fs.accessConditions(in)); err != nil {
+			return errors.Wrapf(err, "failed to delete %s", in.name)
+		}
+		in.etag = azblob.ETagNone
+		in.exists = false
+		in.remoteSize = 0
+	}
+
+	in.blobType = blobType
+	in.dirty = true
+	return fs.syncFile(ctx, in)
+}
+
+// copyXattr copies value into dst following getxattr(2) semantics: an empty
+// dst is a request for the size of the value.
+func copyXattr(dst []byte, value []byte) (n int, err error) {
+	if len(dst) == 0 {
+		return len(value), nil
+	}
+	if len(dst) < len(value) {
+		return 0, syscall.ERANGE
+	}
+	return copy(dst, value), nil
+}
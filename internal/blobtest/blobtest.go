@@ -0,0 +1,810 @@
+// Package blobtest provides an in-memory fake of the parts of the Blob service
+// lightningfs uses, for tests.
+package blobtest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	accountName    = "account"
+	containerName  = "container"
+	metadataPrefix = "x-ms-meta-"
+)
+
+// Blob is a blob, a snapshot of one or a soft deleted one.
+type Blob struct {
+	// Type is the kind of blob. It defaults to a block blob.
+	Type azblob.BlobType
+
+	Name       string
+	Snapshot   string
+	Deleted    bool
+	Data       []byte
+	Metadata   map[string]string
+	ContentMD5 []byte
+	ETag       azblob.ETag
+	Modified   time.Time
+
+	// LeaseID is the ID of the lease held on the blob, if any. Leases don't
+	// expire.
+	LeaseID string
+
+	// BlockIDs are the blocks a block blob was last committed from, in order.
+	BlockIDs []string
+
+	// AccessTier is the tier of a block blob, and ArchiveStatus the progress
+	// of rehydrating it if it's archived. Rehydrating never finishes.
+	AccessTier    azblob.AccessTierType
+	ArchiveStatus azblob.ArchiveStatusType
+}
+
+type key struct {
+	name     string
+	snapshot string
+	deleted  bool
+}
+
+func (b *Blob) blobType() azblob.BlobType {
+	if b.Type == "" {
+		return azblob.BlobBlockBlob
+	}
+	return b.Type
+}
+
+// Server serves a single container of block, page and append blobs.
+type Server struct {
+	*httptest.Server
+
+	// Hook, if set, is called with each request before it's handled. If it
+	// returns a status code, the request fails with it.
+	Hook func(r *http.Request) int
+
+	// CorruptRanges, if set, makes ranged downloads serve data differing from
+	// the MD5 sent for it, as if it were corrupted on the way.
+	CorruptRanges bool
+
+	mu     sync.Mutex
+	blobs  map[key]*Blob
+	blocks map[string]map[string][]byte
+	etags  int
+}
+
+// NewServer starts a server with an empty container. It's stopped with Close.
+func NewServer() *Server {
+	s := &Server{
+		blobs:  make(map[key]*Blob),
+		blocks: make(map[string]map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// ContainerURL returns the URL of the container, which doesn't retry.
+func (s *Server) ContainerURL() azblob.ContainerURL {
+	u, _ := url.Parse(s.URL + "/" + accountName + "/" + containerName)
+	return azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{MaxTries: 1},
+	}))
+}
+
+// Put stores a blob, filling in its ETag and modification time if they're
+// unset.
+func (s *Server) Put(b Blob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(&b)
+}
+
+// Get returns a copy of a blob, which isn't a snapshot or deleted.
+func (s *Server) Get(name string) (Blob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blobs[key{name: name}]
+	if !ok {
+		return Blob{}, false
+	}
+	return *b, true
+}
+
+// Names returns the names of the blobs which aren't snapshots or deleted.
+func (s *Server) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for k := range s.blobs {
+		if k.snapshot == "" && !k.deleted {
+			names = append(names, k.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Touch moves a blob's modification time on, as writing its metadata would.
+func (s *Server) Touch(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.blobs[key{name: name}]; ok {
+		s.modify(b)
+	}
+}
+
+func (s *Server) put(b *Blob) {
+	if b.ETag == "" {
+		s.etags++
+		b.ETag = azblob.ETag(fmt.Sprintf("\"0x%X\"", s.etags))
+	}
+	if b.Modified.IsZero() {
+		b.Modified = time.Now()
+	}
+	b.Modified = b.Modified.UTC().Truncate(time.Second)
+	s.blobs[key{b.Name, b.Snapshot, b.Deleted}] = b
+}
+
+// modify gives a blob a new ETag and a modification time later than its last.
+func (s *Server) modify(b *Blob) {
+	modified := time.Now().UTC().Truncate(time.Second)
+	if !modified.After(b.Modified) {
+		modified = b.Modified.Add(time.Second)
+	}
+	b.ETag, b.Modified = "", modified
+	s.put(b)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Hook != nil {
+		if code := s.Hook(r); code != 0 {
+			writeError(w, code, "InjectedFailure")
+			return
+		}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != accountName || parts[1] != containerName {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeContainerNotFound))
+		return
+	}
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(parts) == 2 {
+		if r.Method == http.MethodGet && q.Get("restype") == "container" && q.Get("comp") == "list" {
+			s.list(w, q)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "UnsupportedOperation")
+		return
+	}
+
+	name := parts[2]
+	b := s.blobs[key{name: name, snapshot: q.Get("snapshot")}]
+	switch {
+	case r.Method == http.MethodHead:
+		s.getProperties(w, r, b)
+	case r.Method == http.MethodGet:
+		s.download(w, r, b)
+	case r.Method == http.MethodDelete:
+		s.delete(w, r, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "":
+		s.upload(w, r, name, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		s.stageBlock(w, r, name, q.Get("blockid"))
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		s.commitBlockList(w, r, name, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "metadata":
+		s.setMetadata(w, r, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "lease":
+		s.lease(w, r, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "page":
+		s.putPages(w, r, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "properties":
+		s.setProperties(w, r, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "appendblock":
+		s.appendBlock(w, r, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "undelete":
+		s.undelete(w, name, b)
+	case r.Method == http.MethodPut && q.Get("comp") == "tier":
+		s.setTier(w, r, b)
+	default:
+		writeError(w, http.StatusBadRequest, "UnsupportedOperation")
+	}
+}
+
+func (s *Server) getProperties(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	writeHeaders(w, b)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Data)))
+	if b.ContentMD5 != nil {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(b.ContentMD5))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if b.AccessTier == azblob.AccessTierArchive {
+		writeError(w, http.StatusConflict, string(azblob.ServiceCodeBlobArchived))
+		return
+	}
+
+	data, status := b.Data, http.StatusOK
+	if rng := r.Header.Get("x-ms-range"); rng != "" {
+		var from, to int64
+		if n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &from, &to); n == 0 || from >= int64(len(b.Data)) {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, string(azblob.ServiceCodeInvalidRange))
+			return
+		} else if n == 1 || to >= int64(len(b.Data)) {
+			to = int64(len(b.Data)) - 1
+		}
+		data, status = b.Data[from:to+1], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to, len(b.Data)))
+	}
+
+	writeHeaders(w, b)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	switch {
+	case r.Header.Get("x-ms-range-get-content-md5") == "true":
+		sum := md5.Sum(data)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	case status == http.StatusOK && b.ContentMD5 != nil:
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(b.ContentMD5))
+	}
+	if status == http.StatusPartialContent && s.CorruptRanges && len(data) > 0 {
+		data = append([]byte(nil), data...)
+		data[0] ^= 1
+	}
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	delete(s.blobs, key{b.Name, b.Snapshot, b.Deleted})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request, name string, b *Blob) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+	blobType := azblob.BlobType(r.Header.Get("x-ms-blob-type"))
+	switch blobType {
+	case azblob.BlobBlockBlob:
+	case azblob.BlobPageBlob:
+		// Page blobs are created empty, at their full size.
+		size, err := strconv.ParseInt(r.Header.Get("x-ms-blob-content-length"), 10, 64)
+		if err != nil || size%azblob.PageBlobPageBytes != 0 {
+			writeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		data = make([]byte, size)
+	case azblob.BlobAppendBlob:
+		// Append blobs are created empty.
+		data = nil
+	default:
+		writeError(w, http.StatusBadRequest, "UnsupportedBlobType")
+		return
+	}
+	s.write(w, r, name, b, blobType, data)
+}
+
+func (s *Server) stageBlock(w http.ResponseWriter, r *http.Request, name string, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil || id == "" {
+		writeError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+	if s.blocks[name] == nil {
+		s.blocks[name] = make(map[string][]byte)
+	}
+	s.blocks[name][id] = data
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) commitBlockList(w http.ResponseWriter, r *http.Request, name string, b *Blob) {
+	var list struct {
+		Latest []string `xml:"Latest"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidXmlDocument")
+		return
+	}
+	var data []byte
+	for _, id := range list.Latest {
+		block, ok := s.blocks[name][id]
+		if !ok {
+			writeError(w, http.StatusBadRequest, string(azblob.ServiceCodeInvalidBlockList))
+			return
+		}
+		data = append(data, block...)
+	}
+	if s.write(w, r, name, b, azblob.BlobBlockBlob, data) {
+		s.blobs[key{name: name}].BlockIDs = list.Latest
+		delete(s.blocks, name)
+	}
+}
+
+// write replaces a blob if the request's conditions are met.
+func (s *Server) write(w http.ResponseWriter, r *http.Request, name string, b *Blob, blobType azblob.BlobType, data []byte) bool {
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return false
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return false
+	}
+	var contentMD5 []byte
+	if v := r.Header.Get("x-ms-blob-content-md5"); v != "" {
+		contentMD5, _ = base64.StdEncoding.DecodeString(v)
+	}
+	next := &Blob{Type: blobType, Name: name, Data: data, Metadata: readMetadata(r), ContentMD5: contentMD5}
+	if b != nil {
+		next.Modified, next.LeaseID = b.Modified, b.LeaseID
+		s.modify(next)
+	} else {
+		s.put(next)
+	}
+	writeHeaders(w, next)
+	w.WriteHeader(http.StatusCreated)
+	return true
+}
+
+func (s *Server) setMetadata(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	b.Metadata = readMetadata(r)
+	s.modify(b)
+	writeHeaders(w, b)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) putPages(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if b.blobType() != azblob.BlobPageBlob {
+		writeError(w, http.StatusConflict, string(azblob.ServiceCodeInvalidBlobType))
+		return
+	}
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+
+	var from, to int64
+	if n, _ := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &from, &to); n != 2 ||
+		from%azblob.PageBlobPageBytes != 0 || (to+1)%azblob.PageBlobPageBytes != 0 || to >= int64(len(b.Data)) {
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, string(azblob.ServiceCodeInvalidPageRange))
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	pages := make([]byte, to+1-from)
+	switch r.Header.Get("x-ms-page-write") {
+	case "update":
+		if err != nil || len(data) != len(pages) {
+			writeError(w, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		copy(pages, data)
+	case "clear":
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+		return
+	}
+
+	// Earlier copies of the blob keep their data.
+	b.Data = append([]byte(nil), b.Data...)
+	copy(b.Data[from:], pages)
+	s.modify(b)
+	writeHeaders(w, b)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// undelete restores a soft deleted blob. A blob which has since been replaced
+// is left as it is.
+func (s *Server) undelete(w http.ResponseWriter, name string, b *Blob) {
+	deleted := s.blobs[key{name: name, deleted: true}]
+	if deleted == nil && b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if deleted != nil {
+		delete(s.blobs, key{name: name, deleted: true})
+		if b == nil {
+			deleted.Deleted = false
+			s.put(deleted)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setTier moves a block blob to another tier. Moving an archived blob out of
+// the archive tier only starts rehydrating it. Neither changes its ETag.
+func (s *Server) setTier(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if b.blobType() != azblob.BlobBlockBlob {
+		writeError(w, http.StatusConflict, string(azblob.ServiceCodeInvalidBlobType))
+		return
+	}
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+
+	tier := azblob.AccessTierType(r.Header.Get("x-ms-access-tier"))
+	switch tier {
+	case azblob.AccessTierHot, azblob.AccessTierCool, azblob.AccessTierArchive:
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+		return
+	}
+	if b.AccessTier == azblob.AccessTierArchive && tier != azblob.AccessTierArchive {
+		b.ArchiveStatus = azblob.ArchiveStatusRehydratePendingToHot
+		if tier == azblob.AccessTierCool {
+			b.ArchiveStatus = azblob.ArchiveStatusRehydratePendingToCool
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	b.AccessTier, b.ArchiveStatus = tier, azblob.ArchiveStatusNone
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) appendBlock(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if b.blobType() != azblob.BlobAppendBlob {
+		writeError(w, http.StatusConflict, string(azblob.ServiceCodeInvalidBlobType))
+		return
+	}
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if v := r.Header.Get("x-ms-blob-condition-appendpos"); v != "" && v != strconv.Itoa(len(b.Data)) {
+		writeError(w, http.StatusPreconditionFailed, string(azblob.ServiceCodeAppendPositionConditionNotMet))
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+
+	// Earlier copies of the blob keep their data.
+	offset := len(b.Data)
+	b.Data = append(append([]byte(nil), b.Data...), data...)
+	s.modify(b)
+	writeHeaders(w, b)
+	w.Header().Set("x-ms-blob-append-offset", strconv.Itoa(offset))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// setProperties resizes a page blob. Other properties aren't kept.
+func (s *Server) setProperties(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	if code, serviceCode := checkLease(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+	if code, serviceCode := checkConditions(r, b); code != 0 {
+		writeError(w, code, serviceCode)
+		return
+	}
+
+	if v := r.Header.Get("x-ms-blob-content-length"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size%azblob.PageBlobPageBytes != 0 || b.blobType() != azblob.BlobPageBlob {
+			writeError(w, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		data := make([]byte, size)
+		copy(data, b.Data)
+		b.Data = data
+	}
+	s.modify(b)
+	writeHeaders(w, b)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) lease(w http.ResponseWriter, r *http.Request, b *Blob) {
+	if b == nil {
+		writeError(w, http.StatusNotFound, string(azblob.ServiceCodeBlobNotFound))
+		return
+	}
+	id := r.Header.Get("x-ms-lease-id")
+	switch r.Header.Get("x-ms-lease-action") {
+	case "acquire":
+		proposed := r.Header.Get("x-ms-proposed-lease-id")
+		if b.LeaseID != "" && b.LeaseID != proposed {
+			writeError(w, http.StatusConflict, string(azblob.ServiceCodeLeaseAlreadyPresent))
+			return
+		}
+		b.LeaseID = proposed
+		w.Header().Set("x-ms-lease-id", b.LeaseID)
+		writeHeaders(w, b)
+		w.WriteHeader(http.StatusCreated)
+	case "renew", "release":
+		if id == "" || id != b.LeaseID {
+			writeError(w, http.StatusConflict, string(azblob.ServiceCodeLeaseIDMismatchWithLeaseOperation))
+			return
+		}
+		if r.Header.Get("x-ms-lease-action") == "renew" {
+			w.Header().Set("x-ms-lease-id", b.LeaseID)
+		} else {
+			b.LeaseID = ""
+		}
+		writeHeaders(w, b)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusBadRequest, "UnsupportedOperation")
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, q url.Values) {
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	include := make(map[string]bool)
+	for _, i := range strings.Split(q.Get("include"), ",") {
+		include[i] = true
+	}
+
+	var blobs []*Blob
+	for k, b := range s.blobs {
+		if !strings.HasPrefix(k.name, prefix) || (k.snapshot != "" && !include["snapshots"]) || (k.deleted && !include["deleted"]) {
+			continue
+		}
+		blobs = append(blobs, b)
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		if blobs[i].Name != blobs[j].Name {
+			return blobs[i].Name < blobs[j].Name
+		}
+		// Snapshots come before their blob.
+		si, sj := blobs[i].Snapshot, blobs[j].Snapshot
+		return si != "" && (sj == "" || si < sj)
+	})
+
+	results := enumerationResults{Prefix: prefix, Delimiter: delimiter}
+	seen := make(map[string]bool)
+	for _, b := range blobs {
+		if delimiter != "" {
+			if i := strings.Index(b.Name[len(prefix):], delimiter); i >= 0 {
+				p := b.Name[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					results.Blobs.Prefixes = append(results.Blobs.Prefixes, blobPrefix{p})
+				}
+				continue
+			}
+		}
+		item := blobItem{
+			Name:     b.Name,
+			Snapshot: b.Snapshot,
+			Deleted:  b.Deleted,
+			Properties: blobProperties{
+				LastModified:  b.Modified.Format(http.TimeFormat),
+				Etag:          string(b.ETag),
+				ContentLength: len(b.Data),
+				BlobType:      string(b.blobType()),
+				AccessTier:    string(b.AccessTier),
+				ArchiveStatus: string(b.ArchiveStatus),
+			},
+		}
+		if b.ContentMD5 != nil {
+			item.Properties.ContentMD5 = base64.StdEncoding.EncodeToString(b.ContentMD5)
+		}
+		if include["metadata"] {
+			item.Metadata = metadata(b.Metadata)
+		}
+		results.Blobs.Items = append(results.Blobs.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(results)
+}
+
+// checkLease returns the status and service code a write fails with if b is
+// leased and the request doesn't carry the lease's ID.
+func checkLease(r *http.Request, b *Blob) (int, string) {
+	if b == nil || b.LeaseID == "" {
+		return 0, ""
+	}
+	switch r.Header.Get("x-ms-lease-id") {
+	case b.LeaseID:
+		return 0, ""
+	case "":
+		return http.StatusPreconditionFailed, string(azblob.ServiceCodeLeaseIDMissing)
+	}
+	return http.StatusPreconditionFailed, string(azblob.ServiceCodeLeaseIDMismatchWithBlobOperation)
+}
+
+// checkConditions returns the status and service code a request fails with
+// if its conditions aren't met by b, which is nil if the blob doesn't exist.
+func checkConditions(r *http.Request, b *Blob) (int, string) {
+	h := r.Header
+	if m := h.Get("If-Match"); m != "" && (b == nil || (m != "*" && azblob.ETag(m) != b.ETag)) {
+		return http.StatusPreconditionFailed, string(azblob.ServiceCodeConditionNotMet)
+	}
+	if m := h.Get("If-None-Match"); m != "" && b != nil {
+		if m == "*" {
+			return http.StatusConflict, string(azblob.ServiceCodeBlobAlreadyExists)
+		}
+		if azblob.ETag(m) == b.ETag {
+			return http.StatusPreconditionFailed, string(azblob.ServiceCodeConditionNotMet)
+		}
+	}
+	if v := h.Get("If-Unmodified-Since"); v != "" && b != nil {
+		if t, err := http.ParseTime(v); err == nil && b.Modified.After(t) {
+			return http.StatusPreconditionFailed, string(azblob.ServiceCodeConditionNotMet)
+		}
+	}
+	if v := h.Get("If-Modified-Since"); v != "" && b != nil {
+		if t, err := http.ParseTime(v); err == nil && !b.Modified.After(t) {
+			return http.StatusPreconditionFailed, string(azblob.ServiceCodeConditionNotMet)
+		}
+	}
+	return 0, ""
+}
+
+func readMetadata(r *http.Request) map[string]string {
+	m := make(map[string]string)
+	for k, v := range r.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, metadataPrefix) {
+			m[strings.TrimPrefix(k, metadataPrefix)] = v[0]
+		}
+	}
+	return m
+}
+
+func writeHeaders(w http.ResponseWriter, b *Blob) {
+	h := w.Header()
+	h.Set("ETag", string(b.ETag))
+	h.Set("Last-Modified", b.Modified.Format(http.TimeFormat))
+	h.Set("x-ms-blob-type", string(b.blobType()))
+	if b.AccessTier != azblob.AccessTierNone {
+		h.Set("x-ms-access-tier", string(b.AccessTier))
+	}
+	if b.ArchiveStatus != azblob.ArchiveStatusNone {
+		h.Set("x-ms-archive-status", string(b.ArchiveStatus))
+	}
+	for k, v := range b.Metadata {
+		h.Set(metadataPrefix+k, v)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, serviceCode string) {
+	w.Header().Set("x-ms-error-code", serviceCode)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	var buf bytes.Buffer
+	xml.NewEncoder(&buf).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: serviceCode, Message: http.StatusText(code)})
+	w.Write(buf.Bytes())
+}
+
+type enumerationResults struct {
+	XMLName   xml.Name `xml:"EnumerationResults"`
+	Prefix    string   `xml:"Prefix"`
+	Delimiter string   `xml:"Delimiter"`
+	Blobs     struct {
+		Prefixes []blobPrefix `xml:"BlobPrefix"`
+		Items    []blobItem   `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+type blobPrefix struct {
+	Name string `xml:"Name"`
+}
+
+type blobItem struct {
+	Name       string         `xml:"Name"`
+	Deleted    bool           `xml:"Deleted,omitempty"`
+	Snapshot   string         `xml:"Snapshot,omitempty"`
+	Properties blobProperties `xml:"Properties"`
+	Metadata   metadata       `xml:"Metadata"`
+}
+
+type blobProperties struct {
+	LastModified  string `xml:"Last-Modified"`
+	Etag          string `xml:"Etag"`
+	ContentLength int    `xml:"Content-Length"`
+	ContentMD5    string `xml:"Content-MD5,omitempty"`
+	BlobType      string `xml:"BlobType"`
+	AccessTier    string `xml:"AccessTier,omitempty"`
+	ArchiveStatus string `xml:"ArchiveStatus,omitempty"`
+}
+
+// metadata marshals each pair as an element named after the key.
+type metadata map[string]string
+
+func (m metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
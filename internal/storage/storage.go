@@ -0,0 +1,23 @@
+// Package storage holds helpers for the errors returned by the Blob service.
+package storage
+
+import "github.com/Azure/azure-storage-blob-go/azblob"
+
+// AsError returns the storage error err was caused by, if any. errors.Cause
+// can't be used for this: storage errors have causes of their own, which are
+// usually nil.
+func AsError(err error) (azblob.StorageError, bool) {
+	for err != nil {
+		if serr, ok := err.(azblob.StorageError); ok {
+			return serr, true
+		}
+		causer, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return nil, false
+		}
+		err = causer.Cause()
+	}
+	return nil, false
+}
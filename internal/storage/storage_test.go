@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/errors"
+)
+
+func TestAsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", string(azblob.ServiceCodeBlobNotFound))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/account/container/blob")
+	blobURL := azblob.NewBlobURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
+	_, err := blobURL.GetProperties(context.Background(), azblob.BlobAccessConditions{})
+
+	for _, test := range []struct {
+		err      error
+		expected bool
+	}{
+		{err, true},
+		{errors.Wrap(err, "failed to get properties"), true},
+		{errors.New("not a storage error"), false},
+		{nil, false},
+	} {
+		serr, ok := AsError(test.err)
+		if ok != test.expected {
+			t.Fatalf("expected %v but got %v for %v", test.expected, ok, test.err)
+		}
+		if ok && serr.Response().StatusCode != http.StatusNotFound {
+			t.Fatalf("expected a 404 but got %d", serr.Response().StatusCode)
+		}
+	}
+}